@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks addresses of unix sockets, e.g. unix:/run/user/1000/multiforward.sock
+const unixPrefix = "unix:"
+
+// adminAPI is a JSON API to inspect and change the forwards of a running instance
+type adminAPI struct {
+	forwarder  *Forwarder
+	namespace  string
+	reportChan chan<- Report
+	// shutdownChan receives a value when the whole instance should be stopped
+	shutdownChan chan<- struct{}
+}
+
+// addForwardRequest is the body of POST /forwards
+type addForwardRequest struct {
+	// Resource is specified as [namespace/]type/name:localPort:remotePort
+	Resource string `json:"resource"`
+}
+
+// errorResponse is returned by the admin API for all failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// newAdminHandler returns the handler of the admin API, forwards are identified by their
+// ID or resource ([namespace/]type/name), path escaped, e.g. /forwards/ns%2Fservice%2Ffoo/restart.
+func newAdminHandler(forwarder *Forwarder, namespace string, reportChan chan<- Report, shutdownChan chan<- struct{}) http.Handler {
+	api := &adminAPI{
+		forwarder:    forwarder,
+		namespace:    namespace,
+		reportChan:   reportChan,
+		shutdownChan: shutdownChan,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /forwards", api.list)
+	mux.HandleFunc("POST /forwards", api.add)
+	mux.HandleFunc("DELETE /forwards/{id}", api.remove)
+	mux.HandleFunc("POST /forwards/{id}/restart", api.restart)
+	mux.HandleFunc("POST /forwards/{id}/repick", api.repick)
+	mux.HandleFunc("POST /shutdown", api.shutdown)
+
+	return mux
+}
+
+func (a *adminAPI) list(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.forwarder.Status())
+}
+
+func (a *adminAPI) add(w http.ResponseWriter, r *http.Request) {
+	var req addForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	resource, err := ParseResource(withNamespace(strings.TrimSpace(req.Resource), a.namespace))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := a.forwarder.Add(resource); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	a.report("added %s", resource)
+
+	for _, s := range a.forwarder.Status() {
+		if s.ID == resource.String() {
+			writeJSON(w, http.StatusCreated, s)
+			return
+		}
+	}
+
+	// removed in the meantime
+	writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrForwardNotFound, resource))
+}
+
+func (a *adminAPI) remove(w http.ResponseWriter, r *http.Request) {
+	a.apply(w, r, "removed", a.forwarder.Remove)
+}
+
+func (a *adminAPI) restart(w http.ResponseWriter, r *http.Request) {
+	a.apply(w, r, "restarted", a.forwarder.Restart)
+}
+
+func (a *adminAPI) repick(w http.ResponseWriter, r *http.Request) {
+	a.apply(w, r, "re-picked pod of", a.forwarder.Repick)
+}
+
+func (a *adminAPI) shutdown(w http.ResponseWriter, _ *http.Request) {
+	select {
+	case a.shutdownChan <- struct{}{}:
+		a.report("shutdown requested")
+	default:
+		// already requested
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// apply calls fn with the forward the request is targeting
+func (a *adminAPI) apply(w http.ResponseWriter, r *http.Request, action string, fn func(target string) error) {
+	target := withNamespace(r.PathValue("id"), a.namespace)
+	if err := fn(target); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	a.report("%s %s", action, target)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) report(format string, args ...any) {
+	a.reportChan <- NewReport(SeverityInfo, nil, format, args...).WithEvent(EventCommand).WithComponent(ComponentAdmin)
+}
+
+// statusCode maps errors of the forwarder to HTTP status codes
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrForwardNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrForwardExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrForwarderStopped):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+// guardAdmin refuses requests of web pages, which can send requests to localhost as well:
+// requests with an Origin or sent to another host name, e.g. by DNS rebinding, are forbidden and
+// changes have to be sent as JSON, which browsers don't send to other origins without a preflight.
+// The host isn't checked for unix sockets, browsers can't connect to them.
+func guardAdmin(next http.Handler, addr string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("requests from %s are not allowed", origin))
+			return
+		}
+
+		if !strings.HasPrefix(addr, unixPrefix) && !isAdminHost(r.Host, addr) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %s is not allowed", r.Host))
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("requests have to be sent with Content-Type: application/json"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdminHost returns true if host is a loopback name or address or the address the API is served on
+func isAdminHost(host, addr string) bool {
+	if strings.EqualFold(host, addr) {
+		return true
+	}
+
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if strings.EqualFold(name, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(name)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// listen listens on a TCP address or a unix socket if addr starts with "unix:". A stale
+// socket left behind by a crashed instance is replaced, only the current user may connect.
+// The listener has to be closed to remove the socket.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing stale socket: %w", err)
+		}
+	}
+
+	// the socket is created accessible by the current user only, it's accepting right away
+	restore := restrictUmask()
+	l, err := net.Listen("unix", path)
+	restore()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("error restricting access to %s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// serveHTTP serves handler on l in the background until l is closed.
+func serveHTTP(l net.Listener, handler http.Handler, component Component, reportChan chan<- Report) {
+	go func() {
+		server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		if err := server.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			reportChan <- NewReport(SeverityError, nil, "server stopped").WithComponent(component).WithErr(err)
+		}
+	}()
+}
+
+// serveAdmin serves the admin API on addr in the background, see newAdminHandler.
+func serveAdmin(addr string, forwarder *Forwarder, namespace string, reportChan chan<- Report, shutdownChan chan<- struct{}) (net.Listener, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	serveHTTP(l, guardAdmin(newAdminHandler(forwarder, namespace, reportChan, shutdownChan), addr), ComponentAdmin, reportChan)
+
+	return l, nil
+}
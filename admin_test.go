@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	shutdownChan := make(chan struct{}, 1)
+	server := httptest.NewServer(newAdminHandler(forwarder, "ns", reportChan, shutdownChan))
+	defer server.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	code, body := do(http.MethodPost, "/forwards", `{"resource": "pod/foo:0:80"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /forwards = %d %s, want 201", code, body)
+	}
+	var added map[string]any
+	if err := json.Unmarshal([]byte(body), &added); err != nil {
+		t.Fatalf("error parsing %s: %v", body, err)
+	}
+	if added["id"] != "ns/pod/foo:0:80" || added["resource"] != "ns/pod/foo" {
+		t.Errorf("POST /forwards returned %s, want forward ns/pod/foo:0:80", body)
+	}
+
+	waitForState(t, forwarder, StateReady)
+
+	code, body = do(http.MethodGet, "/forwards", "")
+	var list []map[string]any
+	if err := json.Unmarshal([]byte(body), &list); err != nil || code != http.StatusOK {
+		t.Fatalf("GET /forwards = %d %s", code, body)
+	}
+	if len(list) != 1 || list[0]["state"] != "Ready" || list[0]["pod"] != "foo" {
+		t.Errorf("GET /forwards returned %s, want a single ready forward", body)
+	}
+
+	id := url.PathEscape("ns/pod/foo:0:80")
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/forwards", `{"resource": "pod/foo:0:80"}`, http.StatusConflict},
+		{http.MethodPost, "/forwards", `{"resource": "foo"}`, http.StatusBadRequest},
+		{http.MethodPost, "/forwards", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/forwards/" + id + "/repick", "", http.StatusNoContent},
+		{http.MethodPost, "/forwards/" + url.PathEscape("pod/foo") + "/restart", "", http.StatusNoContent},
+		{http.MethodPost, "/forwards/" + url.PathEscape("pod/bar") + "/restart", "", http.StatusNotFound},
+		{http.MethodDelete, "/forwards/" + id, "", http.StatusNoContent},
+		{http.MethodDelete, "/forwards/" + id, "", http.StatusNotFound},
+	} {
+		if code, body := do(tt.method, tt.path, tt.body); code != tt.want {
+			t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, code, body, tt.want)
+		}
+	}
+
+	if got := len(forwarder.Status()); got != 0 {
+		t.Errorf("got %d forwards after DELETE, want 0", got)
+	}
+
+	for range 2 {
+		if code, body := do(http.MethodPost, "/shutdown", ""); code != http.StatusAccepted {
+			t.Errorf("POST /shutdown = %d %s, want 202", code, body)
+		}
+	}
+	select {
+	case <-shutdownChan:
+	default:
+		t.Errorf("POST /shutdown didn't request a shutdown")
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// a socket left behind by a crashed instance
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("error creating socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	l, err := listen(unixPrefix + path)
+	if err != nil {
+		t.Fatalf("listen() returned an error for a stale socket: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error checking socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("got permissions %o, want 600", perm)
+	}
+
+	if _, err := listen(unixPrefix + path); err == nil {
+		t.Errorf("listen() succeeded for a socket in use")
+	}
+}
+
+func TestAdminAPIGuard(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	shutdownChan := make(chan struct{}, 1)
+	l, err := serveAdmin("127.0.0.1:0", forwarder, "ns", reportChan, shutdownChan)
+	if err != nil {
+		t.Fatalf("serveAdmin() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	base := "http://" + l.Addr().String()
+
+	for _, tt := range []struct {
+		name, method, path, body string
+		header                   map[string]string
+		want                     int
+	}{
+		{"form", http.MethodPost, "/forwards", "resource=pod/foo:0:80", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"plain text", http.MethodPost, "/forwards", `{"resource": "pod/foo:0:80"}`, map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"no body", http.MethodPost, "/shutdown", "", nil, http.StatusUnsupportedMediaType},
+		{"origin", http.MethodPost, "/shutdown", "", map[string]string{"Content-Type": "application/json", "Origin": "http://example.com"}, http.StatusForbidden},
+		{"host", http.MethodGet, "/forwards", "", map[string]string{"Host": "example.com"}, http.StatusForbidden},
+		{"get", http.MethodGet, "/forwards", "", map[string]string{"Host": "localhost"}, http.StatusOK},
+		{"json", http.MethodPost, "/forwards", `{"resource": "pod/foo:0:80"}`, map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusCreated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, base+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			req.Host = req.Header.Get("Host")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	select {
+	case <-shutdownChan:
+		t.Errorf("expected no shutdown to be requested")
+	default:
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// pcapng format, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngSectionHeader    = 0x0a0d0d0a
+	pcapngInterface        = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1a2b3c4d
+	pcapngOptionEnd        = 0
+	pcapngOptionUserAppl   = 4
+	pcapngOptionIfName     = 2
+	pcapngLinkTypeRaw      = 101
+	pcapngSnapLenUnlimited = 0
+)
+
+// TCP flags of the synthetic segments
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+const (
+	// captureSegmentSize is the maximum payload of a synthetic segment, it fits the IPv4 total length
+	captureSegmentSize = 65535 - 20 - 20
+	// capturePodFallback is the address of pods whose address is unknown
+	capturePodFallback = "192.0.2.1"
+)
+
+// Capture writes the streams of forwarded connections to a pcapng file per forward, wrapped
+// in synthetic TCP/IP segments between the local client and the pod, so they can be decoded
+// by Wireshark. A file is written from the first connection on and closed once the forward
+// is stopped, it's truncated only the first time per process. It's an Observer of the Forwarder.
+type Capture struct {
+	dir        string
+	k8sConfig  *rest.Config
+	reportChan chan<- Report
+
+	mu sync.Mutex
+	// files are the open files by forward ID
+	files map[string]*captureFile
+	// written are the files written by this process, by forward ID
+	written map[string]bool
+	// podIPs are the addresses of pods by namespace/pod, nil while they are fetched
+	podIPs map[string]net.IP
+}
+
+var _ Observer = &Capture{}
+
+func NewCapture(dir string, k8sConfig *rest.Config, reportChan chan<- Report) *Capture {
+	return &Capture{
+		dir:        dir,
+		k8sConfig:  k8sConfig,
+		reportChan: reportChan,
+		files:      make(map[string]*captureFile),
+		written:    make(map[string]bool),
+		podIPs:     make(map[string]net.IP),
+	}
+}
+
+func (c *Capture) StateChanged(status ForwardStatus, _ ForwardState) {
+	if status.State != StateStopped {
+		return
+	}
+
+	c.mu.Lock()
+	f, ok := c.files[status.ID]
+	delete(c.files, status.ID)
+	c.mu.Unlock()
+
+	if ok {
+		f.Close()
+	}
+}
+
+func (c *Capture) PodResolved(status ForwardStatus, _ time.Duration) {
+	// fetched before the first connection to the pod, accepting it mustn't wait for the API
+	c.lookupPodIP(status.Resource.Namespace, status.Pod)
+}
+
+func (c *Capture) ConnectionClosed(ForwardStatus, ConnectionStats) {}
+
+// Close closes all files
+func (c *Capture) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, f := range c.files {
+		f.Close()
+		delete(c.files, id)
+	}
+}
+
+// captureFileName returns the name of the file of a forward, e.g. ns_service_api_8080_80.pcapng
+func captureFileName(id string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(id) + ".pcapng"
+}
+
+// Stream starts the capture of a connection of the forward to port of the pod, it's nil if
+// the file couldn't be opened.
+func (c *Capture) Stream(id string, client net.Addr, namespace, pod string, port uint16) *captureStream {
+	f, err := c.file(id)
+	if err != nil {
+		c.reportChan <- NewReport(SeverityError, nil, "error opening capture file of %s", id).WithComponent(ComponentCapture).WithErr(err)
+		return nil
+	}
+
+	clientAddr, ok := client.(*net.TCPAddr)
+	if !ok {
+		// e.g. a unix socket passed by socket activation
+		clientAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	}
+
+	s := &captureStream{
+		file:       f,
+		clientIP:   clientAddr.IP,
+		clientPort: uint16(clientAddr.Port),
+		podIP:      c.podIP(namespace, pod),
+		podPort:    port,
+	}
+	// both addresses have to be of the same family
+	if s.clientIP.To4() == nil || s.podIP.To4() == nil {
+		s.clientIP, s.podIP = s.clientIP.To16(), s.podIP.To16()
+	} else {
+		s.clientIP, s.podIP = s.clientIP.To4(), s.podIP.To4()
+	}
+
+	s.open()
+	return s
+}
+
+// file returns the open file of the forward, it's opened if it isn't
+func (c *Capture) file(id string) (*captureFile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.files[id]; ok {
+		return f, nil
+	}
+
+	// files of previous runs are replaced, files of restarted forwards get a new section
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if c.written[id] {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(filepath.Join(c.dir, captureFileName(id)), flags, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &captureFile{file: file}
+	if err := f.writeHeader(id); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	c.files[id] = f
+	c.written[id] = true
+	return f, nil
+}
+
+// podIP returns the address of the pod, a documentation address while it's unknown
+func (c *Capture) podIP(namespace, pod string) net.IP {
+	if ip := c.lookupPodIP(namespace, pod); ip != nil {
+		return ip
+	}
+
+	return net.ParseIP(capturePodFallback)
+}
+
+// lookupPodIP returns the address of the pod if it's known, it's fetched in the background otherwise
+func (c *Capture) lookupPodIP(namespace, pod string) net.IP {
+	key := namespace + "/" + pod
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ip, ok := c.podIPs[key]; ok {
+		return ip
+	}
+	c.podIPs[key] = nil
+
+	go func() {
+		addr, err := fetchPodIP(c.k8sConfig, namespace, pod)
+		ip := net.ParseIP(addr)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if err != nil || ip == nil {
+			// fetched again for the next connection, e.g. once the pod is scheduled
+			delete(c.podIPs, key)
+			return
+		}
+		c.podIPs[key] = ip
+	}()
+
+	return nil
+}
+
+// captureFile is a pcapng file with a single interface
+type captureFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+func (f *captureFile) writeHeader(id string) error {
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, pcapngByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	// the section length is unknown
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = appendPcapngOption(shb, pcapngOptionUserAppl, []byte("kubectl-multiforward"))
+	shb = appendPcapngOption(shb, pcapngOptionEnd, nil)
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, pcapngLinkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, pcapngSnapLenUnlimited)
+	idb = appendPcapngOption(idb, pcapngOptionIfName, []byte(id))
+	idb = appendPcapngOption(idb, pcapngOptionEnd, nil)
+
+	block := appendPcapngBlock(nil, pcapngSectionHeader, shb)
+	block = appendPcapngBlock(block, pcapngInterface, idb)
+	_, err := f.file.Write(block)
+
+	return err
+}
+
+// writePacket writes an IP packet captured at t, packets of closed files are dropped
+func (f *captureFile) writePacket(t time.Time, packet []byte) {
+	micros := uint64(t.UnixMicro())
+
+	var epb []byte
+	// interface
+	epb = binary.LittleEndian.AppendUint32(epb, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = append(epb, packet...)
+	epb = append(epb, make([]byte, pad4(len(packet)))...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		_, _ = f.file.Write(appendPcapngBlock(nil, pcapngEnhancedPacket, epb))
+	}
+}
+
+func (f *captureFile) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		_ = f.file.Close()
+	}
+}
+
+// appendPcapngBlock appends a block with the body, which is padded to 32 bits already
+func appendPcapngBlock(b []byte, blockType uint32, body []byte) []byte {
+	length := uint32(12 + len(body))
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+
+	return binary.LittleEndian.AppendUint32(b, length)
+}
+
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+// pad4 returns the number of bytes padding n to 32 bits
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// captureStream writes a connection as TCP segments, starting with the handshake. The data
+// of both directions is passed by the taps of splice.
+type captureStream struct {
+	file                *captureFile
+	clientIP, podIP     net.IP
+	clientPort, podPort uint16
+
+	mu sync.Mutex
+	// clientSeq and podSeq are the next sequence numbers of both sides
+	clientSeq, podSeq uint32
+	closed            bool
+}
+
+func (s *captureStream) open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.segment(true, tcpSYN, nil)
+	s.clientSeq++
+	s.segment(false, tcpSYN|tcpACK, nil)
+	s.podSeq++
+	s.segment(true, tcpACK, nil)
+}
+
+// ToPod captures data sent by the client
+func (s *captureStream) ToPod(p []byte) {
+	s.data(true, p)
+}
+
+// FromPod captures data sent by the pod
+func (s *captureStream) FromPod(p []byte) {
+	s.data(false, p)
+}
+
+func (s *captureStream) data(fromClient bool, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	for len(p) > 0 {
+		n := min(len(p), captureSegmentSize)
+		s.segment(fromClient, tcpPSH|tcpACK, p[:n])
+		if fromClient {
+			s.clientSeq += uint32(n)
+		} else {
+			s.podSeq += uint32(n)
+		}
+		p = p[n:]
+	}
+}
+
+// Close captures the teardown of the connection, started by the side which finished first
+func (s *captureStream) Close(clientFirst bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	s.segment(clientFirst, tcpFIN|tcpACK, nil)
+	s.advance(clientFirst)
+	s.segment(!clientFirst, tcpFIN|tcpACK, nil)
+	s.advance(!clientFirst)
+	s.segment(clientFirst, tcpACK, nil)
+}
+
+func (s *captureStream) advance(client bool) {
+	if client {
+		s.clientSeq++
+	} else {
+		s.podSeq++
+	}
+}
+
+// segment writes a TCP segment of either side, must be called with s.mu held
+func (s *captureStream) segment(fromClient bool, flags byte, payload []byte) {
+	srcIP, dstIP := s.clientIP, s.podIP
+	srcPort, dstPort := s.clientPort, s.podPort
+	seq, ack := s.clientSeq, s.podSeq
+	if !fromClient {
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = ack, seq
+	}
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	// data offset of 5 words
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	pseudo := append(append([]byte{}, srcIP...), dstIP...)
+	if len(srcIP) == net.IPv4len {
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(tcp)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	var packet []byte
+	if len(srcIP) == net.IPv4len {
+		ip := make([]byte, 20, 20+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		// don't fragment
+		ip[6] = 0x40
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		packet = append(ip, tcp...)
+	} else {
+		ip := make([]byte, 40, 40+len(tcp))
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], srcIP)
+		copy(ip[24:], dstIP)
+		packet = append(ip, tcp...)
+	}
+
+	s.file.writePacket(time.Now(), packet)
+}
+
+// checksum returns the internet checksum of the concatenated data, see RFC 1071
+func checksum(data ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var last byte
+	for _, b := range data {
+		for _, v := range b {
+			if odd {
+				sum += uint32(last)<<8 | uint32(v)
+			} else {
+				last = v
+			}
+			odd = !odd
+		}
+	}
+	if odd {
+		sum += uint32(last) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChecksum(t *testing.T) {
+	// the IPv4 header of RFC 1071 examples, with the checksum zeroed
+	header := []byte{0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7}
+	if got := checksum(header); got != 0xb861 {
+		t.Errorf("checksum() = %#04x, want 0xb861", got)
+	}
+	// odd lengths and split data sum up the same
+	if got, want := checksum(header[:7], header[7:]), checksum(header); got != want {
+		t.Errorf("checksum() of split data = %#04x, want %#04x", got, want)
+	}
+}
+
+// capturedSegment is a TCP segment read from a capture file
+type capturedSegment struct {
+	src, dst string
+	flags    byte
+	seq, ack uint32
+	payload  string
+}
+
+// readCapture returns the link type and the TCP segments of a pcapng file of IPv4 packets
+func readCapture(t *testing.T, path string) (uint16, []capturedSegment) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var linkType uint16
+	var segments []capturedSegment
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		blockType := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		body := data[8 : length-4]
+
+		switch blockType {
+		case pcapngSectionHeader:
+			if binary.LittleEndian.Uint32(body) != pcapngByteOrderMagic {
+				t.Fatalf("invalid byte order magic")
+			}
+		case pcapngInterface:
+			linkType = binary.LittleEndian.Uint16(body)
+		case pcapngEnhancedPacket:
+			packet := body[20 : 20+binary.LittleEndian.Uint32(body[12:])]
+			if checksum(packet[:20]) != 0 {
+				t.Errorf("invalid IPv4 header checksum")
+			}
+			tcp := packet[20:]
+			pseudo := append(append([]byte{}, packet[12:20]...), 0, 6)
+			pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+			if checksum(pseudo, tcp) != 0 {
+				t.Errorf("invalid TCP checksum")
+			}
+
+			segments = append(segments, capturedSegment{
+				src:     (&net.TCPAddr{IP: net.IP(packet[12:16]), Port: int(binary.BigEndian.Uint16(tcp))}).String(),
+				dst:     (&net.TCPAddr{IP: net.IP(packet[16:20]), Port: int(binary.BigEndian.Uint16(tcp[2:]))}).String(),
+				flags:   tcp[13],
+				seq:     binary.BigEndian.Uint32(tcp[4:]),
+				ack:     binary.BigEndian.Uint32(tcp[8:]),
+				payload: string(tcp[20:]),
+			})
+		}
+
+		data = data[length:]
+	}
+
+	return linkType, segments
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	config := newFakeAPIServer(t)
+	reportChan := drainReports(t)
+	capture := NewCapture(dir, config, reportChan)
+	forwarder := NewForwarder(config, reportChan, capture)
+	defer forwarder.Stop()
+	forwarder.SetCapture(capture)
+
+	resource := Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}
+	if err := forwarder.Add(resource); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+
+	conn, err := net.Dial("tcp", status.LocalAddresses[0])
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	client := conn.LocalAddr().String()
+	echo(t, conn, "hello")
+	_ = conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := forwarder.Status(); len(s) == 1 && s[0].ActiveConnections == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	capture.Close()
+
+	linkType, segments := readCapture(t, filepath.Join(dir, "ns_pod_foo_0_80.pcapng"))
+	if linkType != pcapngLinkTypeRaw {
+		t.Errorf("got link type %d, want %d", linkType, pcapngLinkTypeRaw)
+	}
+
+	// the fake pod has no address
+	pod := "192.0.2.1:80"
+	want := []capturedSegment{
+		{src: client, dst: pod, flags: tcpSYN},
+		{src: pod, dst: client, flags: tcpSYN | tcpACK, ack: 1},
+		{src: client, dst: pod, flags: tcpACK, seq: 1, ack: 1},
+		{src: client, dst: pod, flags: tcpPSH | tcpACK, seq: 1, ack: 1, payload: "hello\n"},
+		{src: pod, dst: client, flags: tcpPSH | tcpACK, seq: 1, ack: 7, payload: "hello\n"},
+		{src: client, dst: pod, flags: tcpFIN | tcpACK, seq: 7, ack: 7},
+		{src: pod, dst: client, flags: tcpFIN | tcpACK, seq: 7, ack: 8},
+		{src: client, dst: pod, flags: tcpACK, seq: 8, ack: 8},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(segments), len(want), segments)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+}
+
+func TestCapturePodIP(t *testing.T) {
+	config := newFakeCluster(t, map[string]string{
+		"/api/v1/namespaces/ns/pods/foo": `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"foo","namespace":"ns"},"status":{"podIP":"10.0.0.7"}}`,
+		"/api/v1/namespaces/ns/pods/bar": `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"bar","namespace":"ns"}}`,
+	}, func(string, io.ReadWriter) {})
+	capture := NewCapture(t.TempDir(), config, drainReports(t))
+
+	// the address is fetched in the background once the pod is resolved
+	capture.PodResolved(ForwardStatus{Resource: Resource{Type: Pod, Namespace: "ns", Name: "foo"}, Pod: "foo"}, 0)
+	waitForPodIP := func(pod, want string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			capture.mu.Lock()
+			ip, ok := capture.podIPs["ns/"+pod]
+			capture.mu.Unlock()
+			if (want == "" && !ok) || (want != "" && ip.String() == want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("address of %s wasn't fetched", pod)
+	}
+	waitForPodIP("foo", "10.0.0.7")
+	if got := capture.podIP("ns", "foo"); got.String() != "10.0.0.7" {
+		t.Errorf("podIP() = %s, want 10.0.0.7", got)
+	}
+
+	// pods without address get the fallback, which isn't kept
+	if got := capture.podIP("ns", "bar"); got.String() != capturePodFallback {
+		t.Errorf("podIP() = %s, want %s", got, capturePodFallback)
+	}
+	waitForPodIP("bar", "")
+}
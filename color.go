@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ColorMode decides whether output is colored
+type ColorMode string
+
+const (
+	// ColorAuto colors output written to a terminal, unless NO_COLOR is set
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+)
+
+func ColorModeFromString(s string) (ColorMode, error) {
+	switch m := ColorMode(strings.ToLower(s)); m {
+	case ColorAuto, ColorAlways, ColorNever:
+		return m, nil
+	default:
+		return ColorAuto, fmt.Errorf("unknown color mode: %s", s)
+	}
+}
+
+// Enabled reports whether output written to f should be colored.
+func (m ColorMode) Enabled(f *os.File) bool {
+	switch m {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	default:
+		return colorSupported(os.Getenv("NO_COLOR"), os.Getenv("TERM"), term.IsTerminal(int(f.Fd())))
+	}
+}
+
+// colorSupported decides whether color is used in auto mode, see https://no-color.org
+func colorSupported(noColor, termName string, isTerminal bool) bool {
+	return noColor == "" && termName != "dumb" && isTerminal
+}
+
+// resourceColors are used to tell resources apart, severity colors are left out
+var resourceColors = []string{
+	"\033[34m",       // blue
+	"\033[35m",       // magenta
+	"\033[38;5;208m", // orange
+	"\033[38;5;141m", // purple
+	"\033[38;5;39m",  // sky blue
+	"\033[38;5;205m", // pink
+	"\033[38;5;173m", // brown
+	"\033[38;5;110m", // steel blue
+}
+
+// resourceColor returns the color of the given resource, it's the same for every run
+func resourceColor(resource string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(resource))
+
+	return resourceColors[h.Sum32()%uint32(len(resourceColors))]
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestColorSupported(t *testing.T) {
+	tests := []struct {
+		name       string
+		noColor    string
+		termName   string
+		isTerminal bool
+		want       bool
+	}{
+		{name: "terminal", termName: "xterm-256color", isTerminal: true, want: true},
+		{name: "no terminal", termName: "xterm-256color", isTerminal: false, want: false},
+		{name: "NO_COLOR set", noColor: "1", termName: "xterm-256color", isTerminal: true, want: false},
+		{name: "dumb terminal", termName: "dumb", isTerminal: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := colorSupported(tt.noColor, tt.termName, tt.isTerminal); got != tt.want {
+				t.Errorf("colorSupported() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceColor(t *testing.T) {
+	if resourceColor("ns/service/foo") != resourceColor("ns/service/foo") {
+		t.Errorf("resourceColor() isn't stable")
+	}
+
+	colors := make(map[string]bool)
+	for _, r := range []string{"ns/service/foo", "ns/service/bar", "ns/pod/baz", "other/deployment/foo", "ns/service/qux"} {
+		colors[resourceColor(r)] = true
+	}
+	if len(colors) < 2 {
+		t.Errorf("resourceColor() returned the same color for all resources")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type CommandType string
+
+const (
+	CommandAdd     CommandType = "add"
+	CommandRemove  CommandType = "rm"
+	CommandRestart CommandType = "restart"
+	CommandStatus  CommandType = "status"
+)
+
+// Command is a runtime command which changes the set of forwards, e.g.
+// - add [namespace/]type/name:port:port
+// - rm [namespace/]type/name[:port:port]
+// - restart [namespace/]type/name[:port:port]
+// - status
+type Command struct {
+	Type   CommandType
+	Target string
+}
+
+// ParseCommand parses given line into a Command
+func ParseCommand(line string) (Command, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 1 && CommandType(strings.ToLower(fields[0])) == CommandStatus {
+		return Command{Type: CommandStatus}, nil
+	}
+
+	if len(fields) != 2 {
+		return Command{}, fmt.Errorf("invalid command: %q, expected: <add|rm|restart> <resource> or status", line)
+	}
+
+	switch t := CommandType(strings.ToLower(fields[0])); t {
+	case CommandAdd, CommandRemove, CommandRestart:
+		return Command{Type: t, Target: fields[1]}, nil
+	default:
+		return Command{}, fmt.Errorf("unknown command: %s", fields[0])
+	}
+}
+
+// withNamespace prefixes the target with the given namespace if it has none,
+// "service/foo" becomes "ns/service/foo"
+func withNamespace(target, namespace string) string {
+	name, _, _ := strings.Cut(target, ":")
+	if strings.Count(name, "/") == 1 {
+		return namespace + "/" + target
+	}
+
+	return target
+}
+
+// Execute applies the command to the forwarder, output is written to w
+func (c Command) Execute(forwarder *Forwarder, namespace string, w io.Writer) error {
+	target := withNamespace(c.Target, namespace)
+
+	switch c.Type {
+	case CommandStatus:
+		return WriteStatus(w, forwarder.Status(), time.Now())
+	case CommandAdd:
+		r, err := ParseResource(target)
+		if err != nil {
+			return err
+		}
+		return forwarder.Add(r)
+	case CommandRemove:
+		return forwarder.Remove(target)
+	case CommandRestart:
+		return forwarder.Restart(target)
+	default:
+		// cannot happen
+		return fmt.Errorf("unknown command: %s", c.Type)
+	}
+}
+
+// readCommands reads commands line by line from r and executes them until r is exhausted,
+// command output is written to w
+func readCommands(r io.Reader, w io.Writer, forwarder *Forwarder, namespace string, reportChan chan<- Report) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		cmd, err := ParseCommand(line)
+		if err != nil {
+			reportChan <- NewReport(SeverityError, nil, "invalid command").WithEvent(EventCommand).WithComponent(ComponentCommand).WithErr(err)
+			continue
+		}
+
+		if err := cmd.Execute(forwarder, namespace, w); err != nil {
+			if errors.Is(err, ErrForwarderStopped) {
+				return
+			}
+			reportChan <- NewReport(SeverityError, nil, "error executing '%s'", line).WithEvent(EventCommand).WithComponent(ComponentCommand).WithErr(err)
+			continue
+		}
+
+		if cmd.Type != CommandStatus {
+			reportChan <- NewReport(SeverityInfo, nil, "executed '%s'", line).WithEvent(EventCommand).WithComponent(ComponentCommand)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		reportChan <- NewReport(SeverityWarning, nil, "stopped reading commands").WithEvent(EventCommand).WithComponent(ComponentCommand).WithErr(err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Command
+		wantErr error
+	}{
+		{
+			name:    "add",
+			line:    "add ns/service/foo:8080:80",
+			want:    Command{Type: CommandAdd, Target: "ns/service/foo:8080:80"},
+			wantErr: nil,
+		},
+		{
+			name:    "remove with surrounding whitespace",
+			line:    "  rm   ns/service/foo ",
+			want:    Command{Type: CommandRemove, Target: "ns/service/foo"},
+			wantErr: nil,
+		},
+		{
+			name:    "restart in different case",
+			line:    "RESTART service/foo",
+			want:    Command{Type: CommandRestart, Target: "service/foo"},
+			wantErr: nil,
+		},
+		{
+			name:    "status",
+			line:    "status",
+			want:    Command{Type: CommandStatus},
+			wantErr: nil,
+		},
+		{
+			name:    "unknown command",
+			line:    "foo service/foo",
+			want:    Command{},
+			wantErr: fmt.Errorf("unknown command: foo"),
+		},
+		{
+			name:    "missing target",
+			line:    "rm",
+			want:    Command{},
+			wantErr: fmt.Errorf("invalid command: \"rm\", expected: <add|rm|restart> <resource> or status"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.line)
+
+			if !reflect.DeepEqual(tt.wantErr, err) {
+				t.Errorf("got error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got command = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		namespace string
+		want      string
+	}{
+		{
+			name:      "without namespace",
+			target:    "service/foo",
+			namespace: "ns",
+			want:      "ns/service/foo",
+		},
+		{
+			name:      "without namespace but with ports",
+			target:    "service/foo:8080:80",
+			namespace: "ns",
+			want:      "ns/service/foo:8080:80",
+		},
+		{
+			name:      "with namespace",
+			target:    "other/service/foo",
+			namespace: "ns",
+			want:      "other/service/foo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withNamespace(tt.target, tt.namespace); got != tt.want {
+				t.Errorf("withNamespace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
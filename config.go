@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// configPollInterval is the interval the config file is checked for changes
+const configPollInterval = 2 * time.Second
+
+// Config is the content of a forward configuration file, e.g.
+//
+//	namespace: default
+//	hooks:
+//	  onFail: notify-send "$MULTIFORWARD_RESOURCE failed"
+//	forwards:
+//	  - resource: pihole/service/pihole-web:8081:80
+//	  - resource: deployment/backend:8080:8080
+//	    hooks:
+//	      onPodChange: make migrate
+//	  - resource: service/web:8443:80
+//	    tls: true
+type Config struct {
+	// Namespace is used for all forwards without namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Hooks are run for all forwards, unless overridden by the forward
+	Hooks    Hooks           `json:"hooks,omitempty"`
+	Forwards []ForwardConfig `json:"forwards"`
+}
+
+type ForwardConfig struct {
+	// Resource in the format [namespace/]type/name:localPort:remotePort
+	Resource string `json:"resource"`
+	Hooks    Hooks  `json:"hooks,omitempty"`
+	// TLS serves the local port as HTTPS, see TLSTerminator
+	TLS bool `json:"tls,omitempty"`
+}
+
+// ParseConfig parses given yaml document into a Config
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// Resources returns the resources of all configured forwards, namespace is used
+// for the ones which neither have a namespace nor a namespace in the config.
+func (c Config) Resources(namespace string) ([]Resource, error) {
+	if strings.TrimSpace(c.Namespace) != "" {
+		namespace = c.Namespace
+	}
+
+	var resources []Resource
+	seen := make(map[string]bool, len(c.Forwards))
+	for _, fc := range c.Forwards {
+		r, err := ParseResource(fc.Resource)
+		if err != nil {
+			return nil, err
+		}
+		if r.Namespace == "" {
+			r.Namespace = namespace
+		}
+		id := identity(r)
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate forward %s", fc.Resource)
+		}
+		seen[id] = true
+		resources = append(resources, r)
+	}
+
+	return resources, nil
+}
+
+// ForwardHooks returns the hooks of all configured forwards by forward ID, namespace is
+// used like in Resources.
+func (c Config) ForwardHooks(namespace string) (map[string]Hooks, error) {
+	resources, err := c.Resources(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make(map[string]Hooks, len(resources))
+	for i, r := range resources {
+		hooks[r.String()] = c.Forwards[i].Hooks.Or(c.Hooks)
+	}
+
+	return hooks, nil
+}
+
+// TLSForwards returns the IDs of all configured forwards served as HTTPS, namespace is used
+// like in Resources.
+func (c Config) TLSForwards(namespace string) (map[string]bool, error) {
+	resources, err := c.Resources(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for i, r := range resources {
+		if c.Forwards[i].TLS {
+			ids[r.String()] = true
+		}
+	}
+
+	return ids, nil
+}
+
+// ResourceChange is a forward whose local endpoint stays the same but whose target changed
+type ResourceChange struct {
+	Old, New Resource
+}
+
+// ConfigDiff describes how to get from one set of resources to another
+type ConfigDiff struct {
+	Added   []Resource
+	Removed []Resource
+	Changed []ResourceChange
+}
+
+// identity identifies a forward across config changes, a forward of the same
+// resource to the same local port is considered the same forward. Forwards to a
+// local port chosen by the system are identified by their remote port as well.
+func identity(r Resource) string {
+	if local, _, _ := strings.Cut(r.Ports, ":"); local != "0" {
+		return r.Key() + ":" + local
+	}
+
+	return r.String()
+}
+
+// DiffResources computes the changes needed to get from current to desired resources,
+// unchanged resources are not part of the diff.
+func DiffResources(current, desired []Resource) ConfigDiff {
+	currentByIdentity := make(map[string]Resource, len(current))
+	for _, r := range current {
+		currentByIdentity[identity(r)] = r
+	}
+
+	var diff ConfigDiff
+	seen := make(map[string]bool, len(desired))
+	for _, r := range desired {
+		id := identity(r)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		old, ok := currentByIdentity[id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, r)
+		case old != r:
+			diff.Changed = append(diff.Changed, ResourceChange{Old: old, New: r})
+		}
+	}
+
+	for _, r := range current {
+		if !seen[identity(r)] {
+			diff.Removed = append(diff.Removed, r)
+		}
+	}
+
+	return diff
+}
+
+// configReloader keeps the forwards of a config file in sync with the forwarder
+type configReloader struct {
+	path      string
+	namespace string
+	forwarder *Forwarder
+	hooks     *HookRunner
+	// tls serves the forwards selected by the config file as HTTPS, if set
+	tls        *TLSTerminator
+	reportChan chan<- Report
+
+	content []byte
+	current []Resource
+	// tlsForwards are the IDs of the forwards served as HTTPS by the current config
+	tlsForwards map[string]bool
+}
+
+func newConfigReloader(path, namespace string, forwarder *Forwarder, hooks *HookRunner, tls *TLSTerminator, reportChan chan<- Report) *configReloader {
+	return &configReloader{
+		path:       path,
+		namespace:  namespace,
+		forwarder:  forwarder,
+		hooks:      hooks,
+		tls:        tls,
+		reportChan: reportChan,
+	}
+}
+
+// Load reads the config file and applies it to the forwarder, unless its content didn't change.
+func (cr *configReloader) Load() error {
+	content, err := os.ReadFile(cr.path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if cr.content != nil && bytes.Equal(content, cr.content) {
+		return nil
+	}
+	// remember the content even if it's invalid, so errors are reported only once per change
+	cr.content = content
+
+	config, err := ParseConfig(content)
+	if err != nil {
+		return err
+	}
+
+	desired, err := config.Resources(cr.namespace)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := config.ForwardHooks(cr.namespace)
+	if err != nil {
+		return err
+	}
+	// set before forwards are started, so they don't miss any event
+	cr.hooks.SetHooks(hooks)
+
+	// forwards started otherwise, e.g. on the command line, are left alone
+	owned := make(map[string]bool, len(cr.current))
+	for _, r := range cr.current {
+		owned[r.String()] = true
+	}
+	external := make(map[string]bool)
+	for _, r := range cr.forwarder.Resources() {
+		if !owned[r.String()] {
+			external[identity(r)] = true
+		}
+	}
+	desired = slices.DeleteFunc(desired, func(r Resource) bool {
+		if external[identity(r)] {
+			cr.reportChan <- NewReport(SeverityInfo, nil, "config: skipping %s, it's forwarded already", r).WithEvent(EventConfig).WithComponent(ComponentConfig)
+			return true
+		}
+		return false
+	})
+
+	diff := DiffResources(cr.current, desired)
+
+	if cr.tls != nil {
+		ids, err := config.TLSForwards(cr.namespace)
+		if err != nil {
+			return err
+		}
+		cr.tls.SetForwards(ids)
+
+		// forwards are served as HTTPS by their listeners, which are bound on start
+		for _, r := range cr.current {
+			if cr.tlsForwards[r.String()] != ids[r.String()] && slices.Contains(desired, r) {
+				diff.Changed = append(diff.Changed, ResourceChange{Old: r, New: r})
+			}
+		}
+		cr.tlsForwards = ids
+	}
+
+	// forwards of the config are the ones it started, not the ones started otherwise,
+	// which must not be stopped once removed from the config
+	for id := range cr.apply(diff) {
+		owned[id] = true
+	}
+
+	// the forwarder is the source of truth, forwards which couldn't be started
+	// are retried on the next reload
+	running := make(map[string]bool)
+	for _, r := range cr.forwarder.Resources() {
+		running[r.String()] = true
+	}
+
+	cr.current = nil
+	for _, r := range desired {
+		if running[r.String()] && owned[r.String()] {
+			cr.current = append(cr.current, r)
+		}
+	}
+
+	return nil
+}
+
+// apply applies the diff to the forwarder, it returns the IDs of the forwards it started
+func (cr *configReloader) apply(diff ConfigDiff) map[string]bool {
+	for _, r := range diff.Removed {
+		cr.report(r, "stopping removed forward", cr.forwarder.Remove(r.String()))
+	}
+
+	started := make(map[string]bool)
+	for _, c := range diff.Changed {
+		err := cr.forwarder.Remove(c.Old.String())
+		if err == nil {
+			err = cr.forwarder.Add(c.New)
+		}
+		cr.report(c.New, "restarting changed forward", err)
+		if err == nil {
+			started[c.New.String()] = true
+		}
+	}
+
+	for _, r := range diff.Added {
+		err := cr.forwarder.Add(r)
+		cr.report(r, "starting new forward", err)
+		if err == nil {
+			started[r.String()] = true
+		}
+	}
+
+	return started
+}
+
+func (cr *configReloader) report(r Resource, action string, err error) {
+	if err != nil {
+		cr.reportChan <- NewReport(SeverityError, nil, "config: error %s %s", action, r).WithEvent(EventConfig).WithComponent(ComponentConfig).WithErr(err)
+		return
+	}
+
+	cr.reportChan <- NewReport(SeverityInfo, nil, "config: %s %s", action, r).WithEvent(EventConfig).WithComponent(ComponentConfig)
+}
+
+// Watch reloads the config file when it changes or reloadChan receives a value, until stopChan is closed.
+func (cr *configReloader) Watch(reloadChan <-chan os.Signal, stopChan <-chan struct{}) {
+	t := time.NewTicker(configPollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-reloadChan:
+			cr.reportChan <- NewReport(SeverityInfo, nil, "config: reloading %s", cr.path).WithEvent(EventConfig).WithComponent(ComponentConfig)
+			// force re-applying the config, even if the file didn't change
+			cr.content = nil
+		case <-t.C:
+		}
+
+		if err := cr.Load(); err != nil {
+			cr.reportChan <- NewReport(SeverityError, nil, "config: error reloading %s", cr.path).WithEvent(EventConfig).WithComponent(ComponentConfig).WithErr(err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		namespace string
+		want      []Resource
+		wantErr   bool
+	}{
+		{
+			name: "namespace from flag",
+			config: `
+forwards:
+  - resource: service/foo:8080:80
+  - resource: other/pod/bar:9090:9090
+`,
+			namespace: "ns",
+			want: []Resource{
+				{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+				{Type: Pod, Namespace: "other", Name: "bar", Ports: "9090:9090"},
+			},
+		},
+		{
+			name: "namespace from config",
+			config: `
+namespace: cfg
+forwards:
+  - resource: deployment/foo:8080:80
+`,
+			namespace: "ns",
+			want: []Resource{
+				{Type: Deployment, Namespace: "cfg", Name: "foo", Ports: "8080:80"},
+			},
+		},
+		{
+			name: "duplicate forward",
+			config: `
+forwards:
+  - resource: service/foo:8080:80
+  - resource: ns/service/foo:8080:8080
+`,
+			namespace: "ns",
+			wantErr:   true,
+		},
+		{
+			name: "system chosen local ports",
+			config: `
+forwards:
+  - resource: service/foo:0:80
+  - resource: service/foo:0:8080
+`,
+			namespace: "ns",
+			want: []Resource{
+				{Type: Service, Namespace: "ns", Name: "foo", Ports: "0:80"},
+				{Type: Service, Namespace: "ns", Name: "foo", Ports: "0:8080"},
+			},
+		},
+		{
+			name: "invalid resource",
+			config: `
+forwards:
+  - resource: foo/bar:8080:80
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown field",
+			config: `
+forwards:
+  - resource: service/foo:8080:80
+    foo: bar
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(tt.config))
+			var got []Resource
+			if err == nil {
+				got, err = config.Resources(tt.namespace)
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got resources = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffResources(t *testing.T) {
+	foo := Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"}
+	fooChanged := Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:8080"}
+	bar := Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"}
+	baz := Resource{Type: Deployment, Namespace: "ns", Name: "baz", Ports: "7070:80"}
+
+	tests := []struct {
+		name    string
+		current []Resource
+		desired []Resource
+		want    ConfigDiff
+	}{
+		{
+			name:    "nothing changed",
+			current: []Resource{foo, bar},
+			desired: []Resource{bar, foo},
+			want:    ConfigDiff{},
+		},
+		{
+			name:    "initial load",
+			current: nil,
+			desired: []Resource{foo, bar},
+			want:    ConfigDiff{Added: []Resource{foo, bar}},
+		},
+		{
+			name:    "added, removed and changed",
+			current: []Resource{foo, bar},
+			desired: []Resource{fooChanged, baz},
+			want: ConfigDiff{
+				Added:   []Resource{baz},
+				Removed: []Resource{bar},
+				Changed: []ResourceChange{{Old: foo, New: fooChanged}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffResources(tt.current, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffResources() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigForwardHooks(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+hooks:
+  onFail: notify
+  onReady: echo ready
+forwards:
+  - resource: service/foo:8080:80
+  - resource: pod/bar:9090:9090
+    hooks:
+      onReady: echo bar
+      onPodChange: migrate
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() returned an error: %v", err)
+	}
+
+	got, err := config.ForwardHooks("ns")
+	if err != nil {
+		t.Fatalf("ForwardHooks() returned an error: %v", err)
+	}
+
+	want := map[string]Hooks{
+		"ns/service/foo:8080:80": {OnReady: "echo ready", OnFail: "notify"},
+		"ns/pod/bar:9090:9090":   {OnReady: "echo bar", OnPodChange: "migrate", OnFail: "notify"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ForwardHooks() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfigTLSForwards(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+forwards:
+  - resource: service/foo:8443:80
+    tls: true
+  - resource: pod/bar:9090:9090
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() returned an error: %v", err)
+	}
+
+	got, err := config.TLSForwards("ns")
+	if err != nil {
+		t.Fatalf("TLSForwards() returned an error: %v", err)
+	}
+
+	want := map[string]bool{"ns/service/foo:8443:80": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForwards() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfigReloaderTLS(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+	terminator := NewTLSTerminator(t.TempDir(), nil, ListenLoopback, reportChan)
+	forwarder.SetListenFunc(terminator.Listen)
+
+	path := filepath.Join(t.TempDir(), "forwards.yaml")
+	reloader := newConfigReloader(path, "ns", forwarder, NewHookRunner(Hooks{}, reportChan), terminator, reportChan)
+
+	load := func(config string) ForwardStatus {
+		t.Helper()
+
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloader.Load(); err != nil {
+			t.Fatalf("Load() returned an error: %v", err)
+		}
+		return waitForState(t, forwarder, StateReady)
+	}
+
+	handshake := func(status ForwardStatus) error {
+		conn, err := tls.Dial("tcp", status.LocalAddresses[0], &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+
+	status := load("forwards:\n  - resource: pod/foo:0:80\n")
+	if err := handshake(status); err == nil {
+		t.Errorf("expected the forward to be served plain")
+	}
+
+	// the forward is restarted to be served as HTTPS
+	status = load("forwards:\n  - resource: pod/foo:0:80\n    tls: true\n")
+	if err := handshake(status); err != nil {
+		t.Errorf("expected the forward to be served as HTTPS: %v", err)
+	}
+}
+
+func TestConfigReloaderKeepsOtherForwards(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	// started on the command line
+	cli := Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}
+	if err := forwarder.Add(cli); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "forwards.yaml")
+	configReports := make(chan Report, 100)
+	reloader := newConfigReloader(path, "ns", forwarder, NewHookRunner(Hooks{}, reportChan), nil, configReports)
+
+	config := "forwards:\n  - resource: pod/foo:0:80\n  - resource: pod/foo:0:8080\n"
+	for _, config := range []string{config, config, "forwards: []\n"} {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// reloaded even if unchanged, as on SIGHUP
+		reloader.content = nil
+		if err := reloader.Load(); err != nil {
+			t.Fatalf("Load() returned an error: %v", err)
+		}
+	}
+
+	for len(configReports) > 0 {
+		if r := <-configReports; r.Severity == SeverityError {
+			t.Errorf("got error report %s", r)
+		}
+	}
+
+	if got := forwarder.Resources(); len(got) != 1 || got[0] != cli {
+		t.Errorf("got forwards %v after removing them from the config, want only %s", got, cli)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CloseReason describes why a forwarded connection ended
+type CloseReason string
+
+const (
+	// CloseClient means the local client closed the connection
+	CloseClient CloseReason = "client"
+	// ClosePod means the pod closed the connection
+	ClosePod CloseReason = "pod"
+	// CloseError means forwarding failed, e.g. the port isn't open in the pod
+	CloseError CloseReason = "error"
+	// CloseStopped means the forward was stopped while the connection was active
+	CloseStopped CloseReason = "stopped"
+	// CloseNotReady means the connection was refused because the forward wasn't ready
+	CloseNotReady CloseReason = "not_ready"
+)
+
+// ConnectionStats is the accounting of a single local connection
+type ConnectionStats struct {
+	// Client is the address of the local client
+	Client string
+	// Local is the address the connection was accepted on
+	Local string
+	Pod   string
+	Start time.Time
+	End   time.Time
+	// BytesIn is the number of bytes received from the pod, BytesOut sent to the pod
+	BytesIn  uint64
+	BytesOut uint64
+	Reason   CloseReason
+	Err      error
+}
+
+// auditRecord is a single line of the audit log
+type auditRecord struct {
+	Forward    string      `json:"forward"`
+	Resource   string      `json:"resource"`
+	Namespace  string      `json:"namespace"`
+	Pod        string      `json:"pod,omitempty"`
+	RemotePort uint16      `json:"remote_port,omitempty"`
+	Client     string      `json:"client"`
+	Local      string      `json:"local"`
+	Start      time.Time   `json:"start"`
+	End        time.Time   `json:"end"`
+	DurationMS int64       `json:"duration_ms"`
+	BytesIn    uint64      `json:"bytes_in"`
+	BytesOut   uint64      `json:"bytes_out"`
+	Reason     CloseReason `json:"reason"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// AuditLog appends one JSON line per finished connection to a file, it's an Observer of the Forwarder.
+type AuditLog struct {
+	mu         sync.Mutex
+	file       *os.File
+	enc        *json.Encoder
+	reportChan chan<- Report
+}
+
+var _ Observer = &AuditLog{}
+
+// OpenAuditLog opens the audit log at path, records are appended to existing ones.
+func OpenAuditLog(path string, reportChan chan<- Report) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+
+	return &AuditLog{
+		file:       file,
+		enc:        json.NewEncoder(file),
+		reportChan: reportChan,
+	}, nil
+}
+
+func (a *AuditLog) StateChanged(ForwardStatus, ForwardState) {}
+
+func (a *AuditLog) PodResolved(ForwardStatus, time.Duration) {}
+
+func (a *AuditLog) ConnectionClosed(status ForwardStatus, conn ConnectionStats) {
+	record := auditRecord{
+		Forward:    status.ID,
+		Resource:   status.Resource.Key(),
+		Namespace:  status.Resource.Namespace,
+		Pod:        conn.Pod,
+		Client:     conn.Client,
+		Local:      conn.Local,
+		Start:      conn.Start,
+		End:        conn.End,
+		DurationMS: conn.End.Sub(conn.Start).Milliseconds(),
+		BytesIn:    conn.BytesIn,
+		BytesOut:   conn.BytesOut,
+		Reason:     conn.Reason,
+	}
+	if len(status.Ports) > 0 {
+		record.RemotePort = status.Ports[0].Remote
+	}
+	if conn.Err != nil {
+		record.Error = conn.Err.Error()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.enc.Encode(record); err != nil {
+		// must not block the connection, the report is dropped if nobody is listening
+		select {
+		case a.reportChan <- NewReport(SeverityError, nil, "error writing audit log").WithErr(err):
+		default:
+		}
+	}
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Close()
+}
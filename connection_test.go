@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path, drainReports(t))
+	if err != nil {
+		t.Fatalf("OpenAuditLog() returned an error: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	status := ForwardStatus{
+		ID:       "ns/service/foo:8080:80",
+		Resource: Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+		Ports:    []ForwardedPort{{Local: 8080, Remote: 80}},
+	}
+	audit.ConnectionClosed(status, ConnectionStats{
+		Client:   "127.0.0.1:50000",
+		Local:    "127.0.0.1:8080",
+		Pod:      "foo-abc",
+		Start:    start,
+		End:      start.Add(1500 * time.Millisecond),
+		BytesIn:  10,
+		BytesOut: 5,
+		Reason:   ClosePod,
+	})
+	audit.ConnectionClosed(status, ConnectionStats{
+		Client: "127.0.0.1:50001",
+		Local:  "127.0.0.1:8080",
+		Start:  start,
+		End:    start,
+		Reason: CloseError,
+		Err:    errors.New("connection refused"),
+	})
+	if err := audit.Close(); err != nil {
+		t.Fatalf("Close() returned an error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading audit log: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), data)
+	}
+
+	var record auditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("error parsing %s: %v", lines[0], err)
+	}
+	want := auditRecord{
+		Forward:    "ns/service/foo:8080:80",
+		Resource:   "ns/service/foo",
+		Namespace:  "ns",
+		Pod:        "foo-abc",
+		RemotePort: 80,
+		Client:     "127.0.0.1:50000",
+		Local:      "127.0.0.1:8080",
+		Start:      start,
+		End:        start.Add(1500 * time.Millisecond),
+		DurationMS: 1500,
+		BytesIn:    10,
+		BytesOut:   5,
+		Reason:     ClosePod,
+	}
+	if record != want {
+		t.Errorf("got %+v, want %+v", record, want)
+	}
+
+	if !strings.Contains(lines[1], `"reason":"error","error":"connection refused"`) {
+		t.Errorf("got %s, want the error and reason", lines[1])
+	}
+}
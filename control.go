@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// controlSocketName is the name of the unix socket every instance serves the status and stop commands on
+const controlSocketName = "kubectl-multiforward.sock"
+
+// controlTimeout is the time a request to the running instance may take
+const controlTimeout = 30 * time.Second
+
+// controlSocketPath returns the well-known socket of the current user, it's located in
+// XDG_RUNTIME_DIR if set, otherwise in the temp dir with the user ID in its name.
+func controlSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, controlSocketName)
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kubectl-multiforward-%d.sock", os.Getuid()))
+}
+
+// controlClient talks to the admin API of a running instance over its unix socket
+type controlClient struct {
+	socket string
+	client *http.Client
+}
+
+func newControlClient(socket string) *controlClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &controlClient{
+		socket: socket,
+		client: &http.Client{Transport: transport, Timeout: controlTimeout},
+	}
+}
+
+// do sends a request to the running instance, a successful response is decoded into v unless it's nil
+func (c *controlClient) do(method, path string, v any) error {
+	// the host is ignored, all requests are sent to the socket
+	req, err := http.NewRequest(method, "http://multiforward"+path, nil)
+	if err != nil {
+		return err
+	}
+	// required by the admin API for changes
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("no running instance found at %s: %w", c.socket, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("request failed: %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Status returns the status of all forwards of the running instance
+func (c *controlClient) Status() ([]ForwardStatus, error) {
+	var statuses []ForwardStatus
+	if err := c.do(http.MethodGet, "/forwards", &statuses); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// Stop stops all forwards matching the given target
+func (c *controlClient) Stop(target string) error {
+	return c.do(http.MethodDelete, "/forwards/"+url.PathEscape(target), nil)
+}
+
+// Shutdown stops the running instance, it returns once the shutdown was requested.
+func (c *controlClient) Shutdown() error {
+	return c.do(http.MethodPost, "/shutdown", nil)
+}
+
+// newControlHandler returns the handler of the control socket, the read-only part of the
+// admin API and the requests of the stop command. The rest requires --admin-addr.
+func newControlHandler(forwarder *Forwarder, namespace string, reportChan chan<- Report, shutdownChan chan<- struct{}) http.Handler {
+	api := &adminAPI{
+		forwarder:    forwarder,
+		namespace:    namespace,
+		reportChan:   reportChan,
+		shutdownChan: shutdownChan,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /forwards", api.list)
+	mux.HandleFunc("DELETE /forwards/{id}", api.remove)
+	mux.HandleFunc("POST /shutdown", api.shutdown)
+
+	return mux
+}
+
+// serveControl serves the control socket in the background, see newControlHandler.
+func serveControl(socket string, forwarder *Forwarder, namespace string, reportChan chan<- Report, shutdownChan chan<- struct{}) (net.Listener, error) {
+	addr := unixPrefix + socket
+	l, err := listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", socket, err)
+	}
+
+	serveHTTP(l, guardAdmin(newControlHandler(forwarder, namespace, reportChan, shutdownChan), addr), ComponentAdmin, reportChan)
+
+	return l, nil
+}
+
+func newStatusCommand(socket *string) *cobra.Command {
+	return &cobra.Command{
+		Use:           "status",
+		Short:         "Show the forwards of the running instance",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			statuses, err := newControlClient(*socket).Status()
+			if err != nil {
+				return err
+			}
+
+			return writeStatus(cmd.OutOrStdout(), statuses)
+		},
+	}
+}
+
+func writeStatus(w io.Writer, statuses []ForwardStatus) error {
+	if len(statuses) == 0 {
+		_, err := fmt.Fprintln(w, "no forwards")
+		return err
+	}
+
+	return WriteStatus(w, statuses, time.Now())
+}
+
+func newStopCommand(socket *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop [resource]",
+		Short: "Stop a single forward or the whole running instance",
+		Long: `
+Stop a single forward or the whole running instance.
+
+The resource is specified as [namespace/]type/name[:localPort:remotePort],
+the namespace of the running instance is used if none is given.
+Without a resource, the running instance is shut down gracefully.
+`,
+		Args:          cobra.MaximumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := newControlClient(*socket)
+			if len(args) == 0 {
+				return client.Shutdown()
+			}
+
+			return client.Stop(args[0])
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControlCommands(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	shutdownChan := make(chan struct{}, 1)
+	l, err := serveControl(socket, forwarder, "ns", reportChan, shutdownChan)
+	if err != nil {
+		t.Fatalf("serveControl() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	ready := waitForState(t, forwarder, StateReady)
+
+	execute := func(args ...string) (string, error) {
+		t.Helper()
+
+		cmd := newStatusCommand(&socket)
+		if args[0] == "stop" {
+			cmd = newStopCommand(&socket)
+		}
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args[1:])
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	out, err := execute("status")
+	if err != nil {
+		t.Fatalf("status returned an error: %v", err)
+	}
+	if !strings.Contains(out, "ns/pod/foo") || !strings.Contains(out, ready.Ports[0].String()) || !strings.Contains(out, "Ready") {
+		t.Errorf("status printed:\n%s\nwant the ready forward ns/pod/foo on %s", out, ready.Ports[0])
+	}
+
+	if _, err := execute("stop", "pod/bar"); err == nil || !strings.Contains(err.Error(), "forward not found") {
+		t.Errorf("stop pod/bar returned %v, want forward not found", err)
+	}
+
+	if _, err := execute("stop", "pod/foo"); err != nil {
+		t.Fatalf("stop pod/foo returned an error: %v", err)
+	}
+	if out, _ := execute("status"); strings.TrimSpace(out) != "no forwards" {
+		t.Errorf("status printed %q after stop, want no forwards", out)
+	}
+
+	// forwards can't be changed otherwise without --admin-addr
+	client := newControlClient(socket)
+	for _, path := range []string{"/forwards", "/forwards/" + url.PathEscape("pod/foo") + "/restart"} {
+		if err := client.do(http.MethodPost, path, nil); err == nil {
+			t.Errorf("POST %s succeeded on the control socket", path)
+		}
+	}
+
+	if _, err := execute("stop"); err != nil {
+		t.Fatalf("stop returned an error: %v", err)
+	}
+	select {
+	case <-shutdownChan:
+	default:
+		t.Errorf("stop didn't request a shutdown")
+	}
+}
+
+func TestControlCommandsWithoutInstance(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+
+	_, err := newControlClient(socket).Status()
+	if err == nil || !strings.Contains(err.Error(), "no running instance found") {
+		t.Errorf("Status() returned %v, want no running instance found", err)
+	}
+}
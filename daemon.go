@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// detachEnv is set for the instance started in the background by --detach
+const detachEnv = "MULTIFORWARD_DETACHED"
+
+const (
+	// logFileMaxSize is the size a log file is rotated at
+	logFileMaxSize = 10 << 20
+	// logFileBackups is the number of rotated log files which are kept
+	logFileBackups = 3
+)
+
+// errPortClaimed is returned if a local port is claimed by another instance
+var errPortClaimed = errors.New("port is claimed by another instance")
+
+// stateDir returns the directory pid, log and lock files are kept in by default,
+// it's created if it doesn't exist.
+func stateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "kubectl-multiforward")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// isDetached returns true if this is the instance started in the background by --detach
+func isDetached() bool {
+	return os.Getenv(detachEnv) != ""
+}
+
+// detach starts this executable with the given arguments in the background, in a session
+// of its own. Its stdout and stderr are appended to logFile, so errors before logging is
+// set up aren't lost. It returns the pid of the started process.
+func detach(args []string, logFile string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("error opening log file: %w", err)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), detachEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = detachAttr()
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+	// it's not waited for, it outlives this process
+	_ = cmd.Process.Release()
+
+	return pid, nil
+}
+
+// readPidFile returns the pid written to the pid file at path
+func readPidFile(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// checkPidFile returns an error if the pid file at path names a running process
+func checkPidFile(path string) error {
+	pid, err := readPidFile(path)
+	if err != nil || pid == os.Getpid() || !processAlive(pid) {
+		// missing, invalid or stale
+		return nil
+	}
+
+	return fmt.Errorf("already running with pid %d (%s)", pid, path)
+}
+
+// writePidFile writes the pid of this process to path, unless it names another running process
+func writePidFile(path string) error {
+	if err := checkPidFile(path); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(strconv.Itoa(os.Getpid())+"\n"))
+}
+
+// removePidFile removes the pid file at path, if it still names this process
+func removePidFile(path string) {
+	if pid, err := readPidFile(path); err == nil && pid == os.Getpid() {
+		_ = os.Remove(path)
+	}
+}
+
+// portLock claims local ports for this instance, by a lock file per port held until
+// Unlock is called or the process exits.
+type portLock struct {
+	files []*os.File
+}
+
+// lockPorts claims the given local ports by lock files in dir, it fails if any of them
+// is claimed by another instance already. Port 0 is skipped, it's chosen by the system.
+func lockPorts(dir string, ports []uint16) (*portLock, error) {
+	l := &portLock{}
+	for _, port := range ports {
+		if port == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("port-%d.lock", port))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+		if err != nil {
+			l.Unlock()
+			return nil, err
+		}
+
+		if err := lockFile(f); err != nil {
+			_ = f.Close()
+			l.Unlock()
+			if errors.Is(err, errPortClaimed) {
+				if pid, err := readPidFile(path); err == nil {
+					return nil, fmt.Errorf("local port %d: %w (pid %d)", port, errPortClaimed, pid)
+				}
+				return nil, fmt.Errorf("local port %d: %w", port, errPortClaimed)
+			}
+			return nil, err
+		}
+
+		// names the owner in errors of other instances
+		_ = f.Truncate(0)
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+		l.files = append(l.files, f)
+	}
+
+	return l, nil
+}
+
+// Unlock releases all ports
+func (l *portLock) Unlock() {
+	for _, f := range l.files {
+		// closing releases the lock
+		_ = f.Close()
+	}
+	l.files = nil
+}
+
+// localPorts returns the local ports of the given resources, invalid ports are skipped
+func localPorts(resources []Resource) []uint16 {
+	var ports []uint16
+	for _, r := range resources {
+		if p, err := ParsePorts(r.Ports); err == nil {
+			ports = append(ports, p.Local)
+		}
+	}
+
+	return ports
+}
+
+// rotatingFile is a file which is rotated once it would exceed maxSize, the rotated
+// files are kept as path.1, path.2, ... up to backups.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// a single write exceeding the limit is written to an empty file anyway
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a new file
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := func(i int) string {
+		if i == 0 {
+			return r.path
+		}
+		return r.path + "." + strconv.Itoa(i)
+	}
+
+	if r.backups > 0 {
+		for i := r.backups - 1; i >= 0; i-- {
+			if err := os.Rename(backup(i), backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	} else if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,33 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachAttr returns no attributes, the process isn't tied to the terminal by a session
+func detachAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// lockFile doesn't lock, ports are claimed by binding them only
+func lockFile(*os.File) error {
+	return nil
+}
+
+// restrictUmask does nothing, there is no umask
+func restrictUmask() func() {
+	return func() {}
+}
+
+// signalExitCode can't tell, processes aren't killed by signals
+func signalExitCode(*os.ProcessState) (int, bool) {
+	return 0, false
+}
+
+// terminalForeground returns false, there are no process groups
+func terminalForeground() bool {
+	return false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "multiforward.log")
+
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile() returned an error: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() returned an error: %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", p, got, content)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected at most 2 backups, got %s.3", path)
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "multiforward.log")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := openRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatalf("openRotatingFile() returned an error: %v", err)
+	}
+	if _, err := f.Write([]byte("next\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+	_ = f.Close()
+
+	// the size of the existing content counts
+	got, _ := os.ReadFile(path + ".1")
+	if string(got) != "previous\n" {
+		t.Errorf("backup = %q, want %q", got, "previous\n")
+	}
+	got, _ = os.ReadFile(path)
+	if string(got) != "next\n" {
+		t.Errorf("log file = %q, want %q", got, "next\n")
+	}
+}
+
+func TestLockPorts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ports are not locked on windows")
+	}
+
+	dir := t.TempDir()
+
+	lock, err := lockPorts(dir, []uint16{8080, 0, 9090})
+	if err != nil {
+		t.Fatalf("lockPorts() returned an error: %v", err)
+	}
+
+	_, err = lockPorts(dir, []uint16{7070, 9090})
+	if !errors.Is(err, errPortClaimed) {
+		t.Fatalf("expected %v, got %v", errPortClaimed, err)
+	}
+	if !strings.Contains(err.Error(), "port 9090") || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("expected error to name the port and owner, got %q", err)
+	}
+
+	// ports locked before the conflict are released again
+	other, err := lockPorts(dir, []uint16{7070})
+	if err != nil {
+		t.Fatalf("lockPorts() returned an error: %v", err)
+	}
+	other.Unlock()
+
+	lock.Unlock()
+	lock, err = lockPorts(dir, []uint16{9090})
+	if err != nil {
+		t.Fatalf("lockPorts() returned an error after Unlock(): %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "multiforward.pid")
+
+	if err := writePidFile(path); err != nil {
+		t.Fatalf("writePidFile() returned an error: %v", err)
+	}
+	if pid, err := readPidFile(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("readPidFile() = %d, %v, want %d", pid, err, os.Getpid())
+	}
+
+	removePidFile(path)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected pid file to be removed, got %v", err)
+	}
+
+	// pid files of other processes are kept
+	if err := os.WriteFile(path, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	removePidFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected pid file of another process to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// detachAttr starts the process in a new session, so it isn't stopped with the terminal
+func detachAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive returns true if a process with the given pid exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// lockFile locks f exclusively without blocking, the lock is released by the system
+// when f is closed, also if the process crashed.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errPortClaimed
+	}
+
+	return err
+}
+
+// restrictUmask masks all permissions of group and others of files created until the returned
+// func is called, which restores the previous mask. The mask is shared by the whole process.
+func restrictUmask() func() {
+	old := syscall.Umask(0o077)
+	return func() {
+		syscall.Umask(old)
+	}
+}
+
+// signalExitCode returns the exit code of a process killed by a signal as the shell does, 128+signo
+func signalExitCode(state *os.ProcessState) (int, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+
+	return 128 + int(status.Signal()), true
+}
+
+// terminalForeground returns true if this process is in the foreground process group of the
+// terminal of stdin, signals typed on the terminal, e.g. Ctrl+C, reach the whole group then.
+func terminalForeground() bool {
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == syscall.Getpgrp()
+}
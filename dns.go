@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsTTL is short, the local addresses of forwards may change
+	dnsTTL = 5
+	// dnsTimeout limits queries forwarded upstream and idle TCP connections
+	dnsTimeout = 5 * time.Second
+	// dnsMaxUDPSize is the size of the largest UDP message read
+	dnsMaxUDPSize = 4096
+	// dnsListenAttempts is the number of ports tried if the port is chosen by the system
+	dnsListenAttempts = 5
+)
+
+// DNSServer answers A, AAAA and SRV queries for the names of forwarded resources with their
+// local addresses, e.g. <svc>.<ns>.svc.cluster.local, <svc>.<ns> or <svc> for the namespace
+// of the instance. Other queries are forwarded upstream if set, otherwise they are answered
+// with NXDOMAIN.
+type DNSServer struct {
+	forwarder *Forwarder
+	namespace string
+	// upstream is the address of the server other queries are forwarded to, if any
+	upstream   string
+	reportChan chan<- Report
+
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+// serveDNS serves DNS on addr, UDP and TCP, in the background until the server is closed.
+func serveDNS(addr, upstream string, forwarder *Forwarder, namespace string, reportChan chan<- Report) (*DNSServer, error) {
+	if upstream != "" {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+	}
+
+	udp, tcp, err := listenDNS(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	s := &DNSServer{
+		forwarder:  forwarder,
+		namespace:  namespace,
+		upstream:   upstream,
+		reportChan: reportChan,
+		udp:        udp,
+		tcp:        tcp,
+	}
+	go s.serveUDP()
+	go s.serveTCP()
+
+	return s, nil
+}
+
+// listenDNS listens on the same port for UDP and TCP. A port chosen by the system is chosen
+// for TCP and may be in use for UDP already, another one is chosen then.
+func listenDNS(addr string) (net.PacketConn, net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attempts := 1
+	if port == "0" {
+		attempts = dnsListenAttempts
+	}
+
+	for i := 1; ; i++ {
+		tcp, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		udp, err := net.ListenPacket("udp", tcp.Addr().String())
+		if err == nil {
+			return udp, tcp, nil
+		}
+		_ = tcp.Close()
+
+		if i >= attempts || !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, nil, err
+		}
+	}
+}
+
+// Addr returns the address the server is listening on
+func (s *DNSServer) Addr() net.Addr {
+	return s.udp.LocalAddr()
+}
+
+func (s *DNSServer) Close() error {
+	return errors.Join(s.udp.Close(), s.tcp.Close())
+}
+
+func (s *DNSServer) serveUDP() {
+	for {
+		buf := make([]byte, dnsMaxUDPSize)
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.reportChan <- NewReport(SeverityError, nil, "server stopped").WithComponent(ComponentDNS).WithErr(err)
+			}
+			return
+		}
+
+		go func() {
+			if resp := s.handle(buf[:n], "udp"); resp != nil {
+				_, _ = s.udp.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *DNSServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.reportChan <- NewReport(SeverityError, nil, "server stopped").WithComponent(ComponentDNS).WithErr(err)
+			}
+			return
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers queries of a TCP connection until it's closed or idle
+func (s *DNSServer) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for {
+		_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+		req, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		resp := s.handle(req, "tcp")
+		if resp == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// readTCPMessage reads a message prefixed by its length
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, length)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// writeTCPMessage writes a message prefixed by its length
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg))))
+	if err == nil {
+		_, err = w.Write(msg)
+	}
+
+	return err
+}
+
+// handle returns the response to the query req received over network, nil if it's dropped
+func (s *DNSServer) handle(req []byte, network string) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil || h.Response {
+		return nil
+	}
+
+	header := dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		OpCode:             h.OpCode,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: s.upstream != "",
+	}
+
+	q, err := p.Question()
+	if err != nil || h.OpCode != 0 {
+		header.RCode = dnsmessage.RCodeFormatError
+		if h.OpCode != 0 {
+			header.RCode = dnsmessage.RCodeNotImplemented
+		}
+		return buildDNSResponse(header, nil, nil, nil)
+	}
+
+	name := strings.ToLower(q.Name.String())
+	matches := matchForwards(s.forwarder.Status(), name, s.namespace)
+	if len(matches) == 0 {
+		if s.upstream != "" {
+			resp, err := s.forward(req, network)
+			if err == nil {
+				return resp
+			}
+			s.reportChan <- NewReport(SeverityWarning, nil, "error forwarding query for %s", name).WithComponent(ComponentDNS).WithErr(err)
+			header.RCode = dnsmessage.RCodeServerFailure
+		} else {
+			header.RCode = dnsmessage.RCodeNameError
+		}
+		return buildDNSResponse(header, &q, nil, nil)
+	}
+
+	header.Authoritative = true
+	answers, additionals := dnsAnswers(q, matches)
+	s.reportChan <- NewReport(SeverityTrace, nil, "answering %s %s with %d records", q.Type, name, len(answers)).WithComponent(ComponentDNS)
+
+	return buildDNSResponse(header, &q, answers, additionals)
+}
+
+// forward sends the query to the upstream server and returns its response
+func (s *DNSServer) forward(req []byte, network string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, s.upstream, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, req); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsMaxUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+// matchForwards returns the running forwards of the resource the name resolves to, e.g.
+// api.payments.svc.cluster.local, api.payments.svc, api.payments or api in namespace.
+// A _port._proto prefix of SRV queries is ignored.
+func matchForwards(statuses []ForwardStatus, name, namespace string) []ForwardStatus {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for len(labels) > 0 && strings.HasPrefix(labels[0], "_") {
+		labels = labels[1:]
+	}
+
+	resourceName, resourceNamespace, services, err := ParseClusterHost(strings.Join(labels, "."))
+	if err != nil {
+		return nil
+	}
+	qualified := resourceNamespace != ""
+
+	// forwards by resource key, the ones of a single resource are returned
+	byKey := make(map[string][]ForwardStatus)
+	for _, s := range statuses {
+		if s.State == StateStopped || s.State == StateFailed || len(s.LocalAddresses) == 0 {
+			continue
+		}
+		if s.Resource.Name != resourceName || (services && s.Resource.Type != Service) {
+			continue
+		}
+		if qualified && s.Resource.Namespace != resourceNamespace {
+			continue
+		}
+		byKey[s.Resource.Key()] = append(byKey[s.Resource.Key()], s)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key, forwards := range byKey {
+		if !qualified && forwards[0].Resource.Namespace == namespace {
+			// short names prefer the namespace of the instance
+			return forwards
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// services are preferred over pods and deployments of the same name
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := byKey[keys[i]][0].Resource.Type == Service, byKey[keys[j]][0].Resource.Type == Service
+		if si != sj {
+			return si
+		}
+		return keys[i] < keys[j]
+	})
+
+	return byKey[keys[0]]
+}
+
+// dnsAnswers returns the records answering q with the local addresses of the forwards, and
+// the addresses of the targets of SRV records as additionals.
+func dnsAnswers(q dnsmessage.Question, forwards []ForwardStatus) (answers, additionals []dnsmessage.Resource) {
+	header := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: dnsTTL}
+	}
+
+	// addresses of the forwards, each one once
+	var addresses []dnsmessage.Resource
+	seen := make(map[string]bool)
+	for _, f := range forwards {
+		for _, address := range f.LocalAddresses {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil || seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			ip := net.ParseIP(host)
+			if ip4 := ip.To4(); ip4 != nil {
+				addresses = append(addresses, dnsmessage.Resource{Header: header(q.Name, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+			} else if ip != nil {
+				addresses = append(addresses, dnsmessage.Resource{Header: header(q.Name, dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip)}})
+			}
+		}
+	}
+
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		for _, r := range addresses {
+			if r.Header.Type == q.Type {
+				answers = append(answers, r)
+			}
+		}
+	case dnsmessage.TypeSRV:
+		// the target is the name without the _port._proto prefix, it resolves to the addresses
+		labels := strings.Split(q.Name.String(), ".")
+		port := ""
+		for len(labels) > 1 && strings.HasPrefix(labels[0], "_") {
+			if port == "" {
+				port = strings.TrimPrefix(labels[0], "_")
+			}
+			labels = labels[1:]
+		}
+		target := dnsmessage.MustNewName(strings.Join(labels, "."))
+
+		for _, f := range forwards {
+			if len(f.Ports) == 0 {
+				continue
+			}
+			// numeric port names select the remote port, names are unknown to multiforward
+			if _, err := strconv.Atoi(port); err == nil && port != strconv.Itoa(int(f.Ports[0].Remote)) {
+				continue
+			}
+			answers = append(answers, dnsmessage.Resource{
+				Header: header(q.Name, dnsmessage.TypeSRV),
+				Body:   &dnsmessage.SRVResource{Priority: 0, Weight: 100, Port: f.Ports[0].Local, Target: target},
+			})
+		}
+
+		for _, r := range addresses {
+			r.Header.Name = target
+			additionals = append(additionals, r)
+		}
+	}
+
+	return answers, additionals
+}
+
+// buildDNSResponse builds a response with the question q, if any, and the given records
+func buildDNSResponse(header dnsmessage.Header, q *dnsmessage.Question, answers, additionals []dnsmessage.Resource) []byte {
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+
+	build := func() error {
+		if err := b.StartQuestions(); err != nil {
+			return err
+		}
+		if q != nil {
+			if err := b.Question(*q); err != nil {
+				return err
+			}
+		}
+
+		for _, section := range []struct {
+			start   func() error
+			records []dnsmessage.Resource
+		}{
+			{b.StartAnswers, answers},
+			{b.StartAdditionals, additionals},
+		} {
+			if err := section.start(); err != nil {
+				return err
+			}
+			for _, r := range section.records {
+				if err := addDNSResource(&b, r); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := build(); err != nil {
+		return nil
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+func addDNSResource(b *dnsmessage.Builder, r dnsmessage.Resource) error {
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		return b.AResource(r.Header, *body)
+	case *dnsmessage.AAAAResource:
+		return b.AAAAResource(r.Header, *body)
+	case *dnsmessage.SRVResource:
+		return b.SRVResource(r.Header, *body)
+	default:
+		return fmt.Errorf("unsupported record %s", r.Header.Type)
+	}
+}
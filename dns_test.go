@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMatchForwards(t *testing.T) {
+	status := func(typ ResourceType, namespace, name, ports string) ForwardStatus {
+		return ForwardStatus{
+			Resource:       Resource{Type: typ, Namespace: namespace, Name: name, Ports: ports},
+			State:          StateReady,
+			LocalAddresses: []string{"127.0.0.1:8080"},
+		}
+	}
+
+	statuses := []ForwardStatus{
+		status(Service, "payments", "api", "8080:80"),
+		status(Service, "payments", "api", "8443:443"),
+		status(Service, "orders", "api", "9080:80"),
+		status(Pod, "orders", "db-0", "5432:5432"),
+		status(Deployment, "orders", "web", "8081:80"),
+		status(Service, "orders", "web", "8082:80"),
+	}
+	stopped := status(Service, "orders", "cache", "6379:6379")
+	stopped.State = StateStopped
+	statuses = append(statuses, stopped)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "api.payments.svc.cluster.local.", want: []string{"payments/service/api:8080:80", "payments/service/api:8443:443"}},
+		{name: "api.orders.svc.", want: []string{"orders/service/api:9080:80"}},
+		{name: "api.orders.", want: []string{"orders/service/api:9080:80"}},
+		{name: "_http._tcp.api.orders.svc.cluster.local.", want: []string{"orders/service/api:9080:80"}},
+		// the namespace of the instance
+		{name: "api.", want: []string{"payments/service/api:8080:80", "payments/service/api:8443:443"}},
+		{name: "db-0.orders.", want: []string{"orders/pod/db-0:5432:5432"}},
+		{name: "db-0.", want: []string{"orders/pod/db-0:5432:5432"}},
+		// services are preferred
+		{name: "web.orders.", want: []string{"orders/service/web:8082:80"}},
+		{name: "db-0.orders.svc.cluster.local.", want: nil},
+		{name: "cache.orders.", want: nil},
+		{name: "api.payments.example.com.", want: nil},
+		{name: "example.com.", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range matchForwards(statuses, tt.name, "payments") {
+				got = append(got, s.Resource.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matchForwards(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// resolver returns a resolver querying the server, over TCP if tcp is set
+func resolver(server *DNSServer, tcp bool) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if tcp {
+				network = "tcp"
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, server.Addr().String())
+		},
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+
+	server, err := serveDNS("127.0.0.1:0", "", forwarder, "ns", drainReports(t))
+	if err != nil {
+		t.Fatalf("serveDNS() returned an error: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// other queries are forwarded to the upstream server
+	front, err := serveDNS("127.0.0.1:0", server.Addr().String(), NewForwarder(nil, drainReports(t)), "ns", drainReports(t))
+	if err != nil {
+		t.Fatalf("serveDNS() returned an error: %v", err)
+	}
+	defer func() {
+		_ = front.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, tt := range []struct {
+		name   string
+		server *DNSServer
+		tcp    bool
+	}{
+		{name: "udp", server: server},
+		{name: "tcp", server: server, tcp: true},
+		{name: "upstream udp", server: front},
+		{name: "upstream tcp", server: front, tcp: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resolver(tt.server, tt.tcp)
+
+			addrs, err := r.LookupHost(ctx, "foo.ns.")
+			if err != nil {
+				t.Fatalf("LookupHost() returned an error: %v", err)
+			}
+			slices.Sort(addrs)
+			if want := []string{"127.0.0.1", "::1"}; !slices.Equal(addrs, want) && !slices.Equal(addrs, want[:1]) {
+				t.Errorf("LookupHost() = %v, want %v", addrs, want)
+			}
+
+			_, srvs, err := r.LookupSRV(ctx, "http", "tcp", "foo.ns.")
+			if err != nil {
+				t.Fatalf("LookupSRV() returned an error: %v", err)
+			}
+			if len(srvs) != 1 || srvs[0].Port != status.Ports[0].Local || srvs[0].Target != "foo.ns." {
+				t.Errorf("LookupSRV() = %+v, want port %d of foo.ns.", srvs, status.Ports[0].Local)
+			}
+
+			_, err = r.LookupHost(ctx, "bar.ns.")
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+				t.Errorf("expected bar.ns. not to be found, got %v", err)
+			}
+		})
+	}
+}
+
+func TestListenDNS(t *testing.T) {
+	for range 20 {
+		udp, tcp, err := listenDNS("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listenDNS() returned an error: %v", err)
+		}
+		if udp.LocalAddr().String() != tcp.Addr().String() {
+			t.Errorf("listening on %s for UDP and %s for TCP, want the same port", udp.LocalAddr(), tcp.Addr())
+		}
+		_ = udp.Close()
+		_ = tcp.Close()
+	}
+}
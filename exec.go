@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// exitCommandNotFound is the exit code if the command couldn't be started, like the shell's
+const exitCommandNotFound = 127
+
+// execCommand is a command run while all forwards are ready, e.g.
+// kubectl multiforward service/db:5432:5432 -- go test ./integration/...
+type execCommand struct {
+	args []string
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+func newExecCommand(args []string) *execCommand {
+	return &execCommand{args: args}
+}
+
+// Run waits until all forwards are ready, runs the command with the stdio of this process
+// and returns its exit code. If the forwards don't become ready until ctx is done, the
+// command isn't run.
+func (e *execCommand) Run(ctx context.Context, forwarder *Forwarder, reportChan chan<- Report) int {
+	if err := forwarder.WaitReady(ctx); err != nil {
+		reportChan <- NewReport(SeverityError, nil, "not running '%s'", strings.Join(e.args, " ")).WithErr(err)
+		return 1
+	}
+
+	cmd := exec.Command(e.args[0], e.args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	e.mu.Lock()
+	err := cmd.Start()
+	if err == nil {
+		e.cmd = cmd
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		reportChan <- NewReport(SeverityError, nil, "error running '%s'", strings.Join(e.args, " ")).WithErr(err)
+		return exitCommandNotFound
+	}
+
+	reportChan <- NewReport(SeverityDebug, nil, "all forwards are ready, running '%s'", strings.Join(e.args, " "))
+
+	err = cmd.Wait()
+
+	e.mu.Lock()
+	e.cmd = nil
+	e.mu.Unlock()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code, ok := signalExitCode(exitErr.ProcessState); ok {
+			reportChan <- NewReport(SeverityWarning, nil, "'%s' was killed", strings.Join(e.args, " ")).WithErr(err)
+			return code
+		}
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case exitErr != nil && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	default:
+		reportChan <- NewReport(SeverityWarning, nil, "'%s' didn't exit normally", strings.Join(e.args, " ")).WithErr(err)
+		return 1
+	}
+}
+
+// Signal passes sig to the command, it returns false if the command isn't running. An
+// interrupt isn't passed if typed on the terminal, the command received it already.
+func (e *execCommand) Signal(sig os.Signal) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cmd == nil {
+		return false
+	}
+	if sig == os.Interrupt && terminalForeground() {
+		return true
+	}
+
+	return e.cmd.Process.Signal(sig) == nil
+}
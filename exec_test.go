@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by sh")
+	}
+
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"sh", "-c", "exit 0"}, want: 0},
+		{args: []string{"sh", "-c", "exit 3"}, want: 3},
+		// killed by SIGTERM
+		{args: []string{"sh", "-c", "kill -TERM $$"}, want: 128 + 15},
+		{args: []string{"does-not-exist"}, want: exitCommandNotFound},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if got := newExecCommand(tt.args).Run(ctx, forwarder, reportChan); got != tt.want {
+			t.Errorf("Run(%v) = %d, want %d", tt.args, got, tt.want)
+		}
+		cancel()
+	}
+}
+
+func TestExecCommandNotReady(t *testing.T) {
+	reportChan := drainReports(t)
+	forwarder := NewForwarder(newFakeAPIServer(t), reportChan)
+	defer forwarder.Stop()
+
+	// the remote port is invalid, the forward fails permanently
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:0"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if got := newExecCommand([]string{"does-not-exist"}).Run(ctx, forwarder, reportChan); got != 1 {
+		t.Errorf("Run() = %d, want 1", got)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	for _, r := range []Resource{
+		{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"},
+		{Type: Pod, Namespace: "ns", Name: "missing", Ports: "0:80"},
+	} {
+		if err := forwarder.Add(r); err != nil {
+			t.Fatalf("Add() returned an error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	err := forwarder.WaitReady(ctx)
+	if !errors.Is(err, ErrForwardNotReady) || !strings.Contains(err.Error(), "ns/pod/missing:0:80") || strings.Contains(err.Error(), "foo") {
+		t.Errorf("WaitReady() returned %v, want only ns/pod/missing:0:80 not to be ready", err)
+	}
+
+	if err := forwarder.Remove("ns/pod/missing"); err != nil {
+		t.Fatalf("Remove() returned an error: %v", err)
+	}
+	if err := forwarder.WaitReady(context.Background()); err != nil {
+		t.Errorf("WaitReady() returned %v, want nil", err)
+	}
+}
@@ -1,190 +1,860 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
-	"net/http"
-	"net/url"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// restartDelay is the time to wait before a failed forward is established again
+const restartDelay = 5 * time.Second
+
+// readyPollInterval is the interval the states of forwards are checked while waiting for them to be ready
+const readyPollInterval = 100 * time.Millisecond
+
+const (
+	// drainPollInterval is the interval active connections are checked while draining
+	drainPollInterval = 100 * time.Millisecond
+	// drainReportInterval is the interval the number of remaining connections is reported while draining
+	drainReportInterval = 2 * time.Second
+)
+
+// errInterrupted is returned when the connection to the pod was closed on purpose to reconnect
+var errInterrupted = errors.New("connection to pod interrupted")
+
+var (
+	ErrForwardExists    = errors.New("forward already exists")
+	ErrForwardNotFound  = errors.New("forward not found")
+	ErrForwarderStopped = errors.New("forwarder is stopped")
+	ErrForwardNotReady  = errors.New("forward not ready")
+)
+
+// Observer is notified about the lifecycle of forwards. Methods are called
+// synchronously by the goroutine of the forward and must not block.
+type Observer interface {
+	// StateChanged is called after the forward moved from previous into status.State
+	StateChanged(status ForwardStatus, previous ForwardState)
+	// PodResolved is called after a pod was picked for the forward, took is the time it took
+	PodResolved(status ForwardStatus, took time.Duration)
+	// ConnectionClosed is called after a local connection of the forward was closed
+	ConnectionClosed(status ForwardStatus, conn ConnectionStats)
+}
+
+// ListenFunc binds the local listeners of a forward, port.Local may be 0 to let the
+// system choose one. All listeners must be bound to the same port.
+type ListenFunc func(resource Resource, port ForwardedPort) ([]net.Listener, error)
+
+// Forwarder maintains a set of port forwards, forwards can be added,
+// removed and restarted while the forwarder is running.
 type Forwarder struct {
-	k8sConfig *rest.Config
+	k8sConfig  *rest.Config
+	reportChan chan<- Report
+	observers  []Observer
+	listenFunc ListenFunc
+	// capture writes the streams of all connections to files, if set
+	capture *Capture
+
+	mu       sync.Mutex
+	forwards map[string]*forward
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// forward is a single port forward which is restarted until it gets stopped
+type forward struct {
+	resource Resource
+	poder    Poder
+	stopChan chan struct{}
+	stopOnce sync.Once
+	doneChan chan struct{}
+	// interruptChan makes the forward reconnect
+	interruptChan chan struct{}
+
+	bytesIn, bytesOut atomic.Uint64
+	connections       atomic.Uint64
+
+	mu     sync.Mutex
+	status ForwardStatus
+	// listeners accept local connections, they are kept across reconnects
+	listeners []net.Listener
+	// draining is set once the forward doesn't accept new connections anymore
+	draining bool
+	// tunnel is the connection to the pod, set while the forward is ready
+	tunnel *podTunnel
+	conns  map[net.Conn]struct{}
+	// avoidPod is not picked on the next attempt if there are other pods
+	avoidPod string
 }
 
-func NewForwarder(k8sConfig *rest.Config) Forwarder {
-	return Forwarder{
-		k8sConfig: k8sConfig,
+// Status returns a snapshot of the forward status
+func (fw *forward) Status() ForwardStatus {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	status := fw.status
+	status.Ports = append([]ForwardedPort(nil), fw.status.Ports...)
+	status.LocalAddresses = append([]string(nil), fw.status.LocalAddresses...)
+	status.ActiveConnections = len(fw.conns)
+	status.BytesIn = fw.bytesIn.Load()
+	status.BytesOut = fw.bytesOut.Load()
+	status.Connections = fw.connections.Load()
+
+	return status
+}
+
+// report creates a report about the forward, including the current pod.
+func (fw *forward) report(severity Severity, format string, a ...any) Report {
+	fw.mu.Lock()
+	pod := fw.status.Pod
+	fw.mu.Unlock()
+
+	return NewReport(severity, fw.poder, format, a...).WithComponent(ComponentForwarder).WithPod(pod)
+}
+
+// stop signals the forward to stop, it's safe to be called multiple times.
+func (fw *forward) stop() {
+	fw.stopOnce.Do(func() {
+		close(fw.stopChan)
+	})
+}
+
+func (fw *forward) isStopped() bool {
+	select {
+	case <-fw.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeListeners stops accepting new connections, active connections are kept.
+func (fw *forward) closeListeners() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.draining = true
+	for _, l := range fw.listeners {
+		_ = l.Close()
 	}
+	fw.listeners = nil
 }
 
-type ForwardResult struct {
-	Source Poder
-	Err    error
+// closeConnections closes all active connections.
+func (fw *forward) closeConnections() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	for conn := range fw.conns {
+		_ = conn.Close()
+	}
 }
 
-func (fr ForwardResult) IsError() bool {
-	return fr.Err != nil
+func (fw *forward) activeConnections() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return len(fw.conns)
 }
 
-func NewForwardResult(poder Poder) ForwardResult {
-	return ForwardResult{
-		Source: poder,
+func (fw *forward) localAddress() string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	var addresses []string
+	for _, l := range fw.listeners {
+		addresses = append(addresses, l.Addr().String())
 	}
+
+	return strings.Join(addresses, ", ")
 }
 
-func NewForwardResultWithError(poder Poder, err error) ForwardResult {
-	return ForwardResult{
-		Source: poder,
-		Err:    err,
+// waitReady blocks until the forward is ready, it returns false if it's stopped or draining meanwhile.
+func (fw *forward) waitReady() bool {
+	t := time.NewTicker(readyPollInterval)
+	defer t.Stop()
+
+	for {
+		fw.mu.Lock()
+		ready := fw.tunnel != nil && fw.status.State == StateReady
+		draining := fw.draining
+		fw.mu.Unlock()
+
+		if ready {
+			return true
+		}
+		if draining {
+			return false
+		}
+
+		select {
+		case <-fw.stopChan:
+			return false
+		case <-t.C:
+		}
 	}
 }
 
-// forwardSingle establishes a single port forwarding connection for a given Poder.
-func (f Forwarder) forwardSingle(
-	wg *sync.WaitGroup,
-	poder Poder,
-	resultsChan chan<- ForwardResult,
-	stopChan <-chan struct{},
-	reportChan chan<- Report,
-) error {
-	pod, err := poder.Pod()
-	if err != nil {
-		return fmt.Errorf("couldn't establish port forwarding -> %s", err)
+func (fw *forward) setTunnel(tunnel *podTunnel) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.tunnel = tunnel
+}
+
+// track registers an active connection, it returns the tunnel to forward the connection
+// to, or false if the forward isn't ready.
+func (fw *forward) track(conn net.Conn) (*podTunnel, ForwardedPort, bool) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.tunnel == nil || fw.status.State != StateReady || len(fw.status.Ports) == 0 {
+		return nil, ForwardedPort{}, false
 	}
 
-	roundTripper, upgrader, err := spdy.RoundTripperFor(f.k8sConfig)
-	if err != nil {
-		return fmt.Errorf("error building round tripper: %w", err)
+	fw.conns[conn] = struct{}{}
+	fw.connections.Add(1)
+
+	return fw.tunnel, fw.status.Ports[0], true
+}
+
+func (fw *forward) untrack(conn net.Conn) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	delete(fw.conns, conn)
+}
+
+// setState moves the forward into the given state, update is applied to the status while holding the lock.
+func (fw *forward) setState(state ForwardState, update func(*ForwardStatus)) (previous ForwardState) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	previous = fw.status.State
+	if update != nil {
+		update(&fw.status)
+	}
+	if previous != state {
+		fw.status.State = state
+		fw.status.Since = time.Now()
 	}
 
-	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", poder.Namespace(), pod)
+	return previous
+}
 
-	serverURL, err := url.Parse(f.k8sConfig.Host + path)
-	if err != nil {
-		return fmt.Errorf("error parsing k8s server URL '%s'  -> %s", f.k8sConfig.Host, err)
+// NewForwarder creates a forwarder, the given observers are notified about all its forwards.
+func NewForwarder(k8sConfig *rest.Config, reportChan chan<- Report, observers ...Observer) *Forwarder {
+	return &Forwarder{
+		k8sConfig:  k8sConfig,
+		reportChan: reportChan,
+		observers:  observers,
+		listenFunc: ListenLoopback,
+		forwards:   make(map[string]*forward),
+	}
+}
+
+// SetListenFunc replaces the way local listeners are bound, ListenLoopback by default.
+// It must be called before forwards are added.
+func (f *Forwarder) SetListenFunc(listen ListenFunc) {
+	f.listenFunc = listen
+}
+
+// SetCapture makes the forwarder write the streams of all connections to capture.
+// It must be called before forwards are added.
+func (f *Forwarder) SetCapture(capture *Capture) {
+	f.capture = capture
+}
+
+// ListenLoopback binds the local port on 127.0.0.1 and ::1, it succeeds if any of them could be bound.
+func ListenLoopback(_ Resource, port ForwardedPort) ([]net.Listener, error) {
+	var listeners []net.Listener
+	var errs []error
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(int(port.Local))))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// a dynamically allocated port must be the same on all addresses
+		port.Local = uint16(l.Addr().(*net.TCPAddr).Port)
+		listeners = append(listeners, l)
 	}
 
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, serverURL)
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("unable to listen on any of the requested ports: %w", errors.Join(errs...))
+	}
+
+	return listeners, nil
+}
 
-	readyChan := make(chan struct{}, 1)
-	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+// Add starts forwarding the given resource.
+func (f *Forwarder) Add(resource Resource) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopped {
+		return ErrForwarderStopped
+	}
+
+	id := resource.String()
+	if _, ok := f.forwards[id]; ok {
+		return fmt.Errorf("%w: %s", ErrForwardExists, id)
+	}
 
-	oldRuntimeErrorHandlers := runtime.ErrorHandlers
-	runtime.ErrorHandlers = []runtime.ErrorHandler{
-		func(_ context.Context, err error, _ string, _ ...interface{}) {
-			reportChan <- NewReport(SeverityError, poder, "%s", err.Error())
+	now := time.Now()
+	fw := &forward{
+		resource:      resource,
+		poder:         NewPoder(f.k8sConfig, resource),
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+		interruptChan: make(chan struct{}, 1),
+		conns:         make(map[net.Conn]struct{}),
+		status: ForwardStatus{
+			ID:        id,
+			Resource:  resource,
+			State:     StateResolving,
+			CreatedAt: now,
+			Since:     now,
 		},
 	}
+	f.forwards[id] = fw
 
-	if len(oldRuntimeErrorHandlers) >= 2 {
-		runtime.ErrorHandlers = append(runtime.ErrorHandlers, oldRuntimeErrorHandlers[1])
+	f.wg.Add(1)
+	go f.run(fw)
+
+	return nil
+}
+
+// Remove stops and removes all forwards matching the given target, which is
+// either a resource with ports (ns/type/name:port:port) or without (ns/type/name).
+func (f *Forwarder) Remove(target string) error {
+	f.mu.Lock()
+	forwards := f.find(target)
+	for _, fw := range forwards {
+		delete(f.forwards, fw.resource.String())
 	}
+	f.mu.Unlock()
 
-	forwarder, err := portforward.New(dialer, poder.Ports(), stopChan, readyChan, out, errOut)
-	if err != nil {
-		return fmt.Errorf("error creating port forwarder: %w", err)
+	if len(forwards) == 0 {
+		return fmt.Errorf("%w: %s", ErrForwardNotFound, target)
 	}
 
-	go func() {
-		// Kubernetes will close this channel when it has something to tell us
-		<-readyChan
+	for _, fw := range forwards {
+		fw.stop()
+		<-fw.doneChan
+	}
 
-		if len(errOut.String()) != 0 {
-			reportChan <- NewReport(SeverityError, poder, "%s", strings.TrimSpace(strings.ReplaceAll(out.String(), "\n", "; ")))
-		}
+	return nil
+}
+
+// Restart stops all forwards matching the given target and starts them again.
+func (f *Forwarder) Restart(target string) error {
+	f.mu.Lock()
+	forwards := f.find(target)
+	f.mu.Unlock()
+
+	if len(forwards) == 0 {
+		return fmt.Errorf("%w: %s", ErrForwardNotFound, target)
+	}
 
-		if len(out.String()) != 0 {
-			reportChan <- NewReport(SeverityInfo, poder, "%s", strings.TrimSpace(strings.ReplaceAll(out.String(), "\n", "; ")))
+	if err := f.Remove(target); err != nil {
+		return err
+	}
+
+	for _, fw := range forwards {
+		if err := f.Add(fw.resource); err != nil {
+			return err
 		}
-	}()
+	}
 
-	go func() {
-		defer wg.Done()
+	return nil
+}
+
+// Repick reconnects all forwards matching the given target to another pod of their resource,
+// if there is one.
+func (f *Forwarder) Repick(target string) error {
+	f.mu.Lock()
+	forwards := f.find(target)
+	f.mu.Unlock()
+
+	if len(forwards) == 0 {
+		return fmt.Errorf("%w: %s", ErrForwardNotFound, target)
+	}
 
-		reportChan <- NewReport(SeverityDebug, poder, "establishing port forwarding for %s ...", pod)
+	for _, fw := range forwards {
+		fw.mu.Lock()
+		fw.avoidPod = fw.status.Pod
+		fw.mu.Unlock()
 
-		if err = forwarder.ForwardPorts(); err != nil {
-			reportChan <- NewReport(SeverityError, poder, "error forwarding ports: %s", err.Error())
-			resultsChan <- NewForwardResultWithError(poder, err)
+		select {
+		case fw.interruptChan <- struct{}{}:
+		default:
+			// already interrupted
 		}
-		resultsChan <- NewForwardResult(poder)
-	}()
+	}
 
 	return nil
 }
 
-func (f Forwarder) forwardSingleInALoop(
-	wg *sync.WaitGroup,
-	poder Poder,
-	resultsChan chan<- ForwardResult,
-	stopChan <-chan struct{},
-	reportChan chan<- Report,
-) {
-	t := time.NewTicker(5 * time.Second)
+// Resources returns the resources of all forwards.
+func (f *Forwarder) Resources() []Resource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resources := make([]Resource, 0, len(f.forwards))
+	for _, fw := range f.forwards {
+		resources = append(resources, fw.resource)
+	}
+
+	return resources
+}
+
+// Status returns a snapshot of the status of all forwards, ordered by ID.
+func (f *Forwarder) Status() []ForwardStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	statuses := make([]ForwardStatus, 0, len(f.forwards))
+	for _, fw := range f.forwards {
+		statuses = append(statuses, fw.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return statuses
+}
+
+// WaitReady blocks until all forwards are ready at the same time. It fails if a forward
+// failed permanently or ctx is done before, the error names the forwards which aren't ready.
+func (f *Forwarder) WaitReady(ctx context.Context) error {
+	t := time.NewTicker(readyPollInterval)
+	defer t.Stop()
+
+	for {
+		var notReady []string
+		for _, s := range f.Status() {
+			switch s.State {
+			case StateReady:
+			case StateFailed, StateStopped:
+				err := fmt.Errorf("%w: %s is %s", ErrForwardNotReady, s.ID, strings.ToLower(s.State.String()))
+				if s.LastError != nil {
+					err = fmt.Errorf("%w: %w", err, s.LastError)
+				}
+				return err
+			default:
+				notReady = append(notReady, s.ID)
+			}
+		}
+
+		if len(notReady) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", ErrForwardNotReady, strings.Join(notReady, ", "))
+		case <-t.C:
+		}
+	}
+}
+
+// Stop stops all forwards and waits until they are finished, no forwards
+// can be added afterward. The stopped forwards are kept to report their status.
+func (f *Forwarder) Stop() {
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return
+	}
+	f.stopped = true
+	for _, fw := range f.forwards {
+		fw.stop()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
+
+// Shutdown stops accepting new connections and waits until all active connections are
+// finished or ctx is done, afterward all forwards are stopped.
+func (f *Forwarder) Shutdown(ctx context.Context) {
+	f.mu.Lock()
+	f.stopped = true
+	forwards := make([]*forward, 0, len(f.forwards))
+	for _, fw := range f.forwards {
+		forwards = append(forwards, fw)
+	}
+	f.mu.Unlock()
+
+	for _, fw := range forwards {
+		fw.closeListeners()
+	}
+
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+
+	reported := time.Now()
 	for {
+		active := 0
+		for _, fw := range forwards {
+			active += fw.activeConnections()
+		}
+
+		if active == 0 {
+			break
+		}
+
+		if time.Since(reported) >= drainReportInterval {
+			f.reportChan <- NewReport(SeverityInfo, nil, "waiting for %d active connections to finish...", active).WithComponent(ComponentForwarder)
+			reported = time.Now()
+		}
+
 		select {
-		case <-stopChan:
-			reportChan <- NewReport(SeverityInfo, poder, "received stop signal, no more attempts to restart forwarder")
+		case <-ctx.Done():
+			f.reportChan <- NewReport(SeverityWarning, nil, "closing %d active connections", active).WithComponent(ComponentForwarder)
+			f.stopAll(forwards)
 			return
 		case <-t.C:
-			reportChan <- NewReport(SeverityTrace, poder, "trying to restart forwarder...")
-			if err := f.forwardSingle(wg, poder, resultsChan, stopChan, reportChan); err == nil {
-				t.Stop()
-				wg.Add(1)
-				reportChan <- NewReport(SeverityInfo, poder, "restarted forwarder...")
-				return
-			}
-			// just do it again until err == nil
 		}
 	}
+
+	f.stopAll(forwards)
 }
 
-// Forward establishes port forwarding for all given Poder instances.
-func (f Forwarder) Forward(
-	poders []Poder,
-	stopChan <-chan struct{},
-	reportChan chan<- Report,
-) (<-chan struct{}, error) {
-	resultsChan := make(chan ForwardResult, len(poders))
-	targetStopChan := make(chan struct{})
-	doneChan := make(chan struct{})
-	wg := sync.WaitGroup{}
+// stopAll stops the given forwards and waits until they are finished.
+func (f *Forwarder) stopAll(forwards []*forward) {
+	for _, fw := range forwards {
+		fw.stop()
+	}
 
-	go func() {
-		defer close(resultsChan)
-		defer close(doneChan)
+	f.wg.Wait()
+}
 
-	loop:
-		for {
-			select {
-			case result := <-resultsChan:
-				if result.IsError() {
-					go f.forwardSingleInALoop(&wg, result.Source, resultsChan, targetStopChan, reportChan)
-				}
-			case <-stopChan:
-				reportChan <- NewReport(SeverityInfo, nil, "received stop signal, stopping all forwarders...")
-				close(targetStopChan)
-				wg.Wait()
-				reportChan <- NewReport(SeverityInfo, nil, "all forwarders stopped")
-				break loop
-			}
+// Forward establishes port forwarding for all given resources, the forwards
+// are kept running until the forwarder is stopped.
+func (f *Forwarder) Forward(resources []Resource) error {
+	for _, resource := range resources {
+		if err := f.Add(resource); err != nil {
+			f.Stop()
+			return fmt.Errorf("error starting forwarder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// find returns all forwards matching given target, must be called with f.mu held.
+func (f *Forwarder) find(target string) []*forward {
+	var forwards []*forward
+	for id, fw := range f.forwards {
+		if id == target || fw.resource.Key() == target {
+			forwards = append(forwards, fw)
+		}
+	}
+
+	return forwards
+}
+
+// run keeps the forward running until it gets stopped or fails permanently.
+func (f *Forwarder) run(fw *forward) {
+	defer f.wg.Done()
+	defer close(fw.doneChan)
+	defer fw.closeConnections()
+	defer fw.closeListeners()
+
+	for {
+		err := f.forwardSingle(fw)
+
+		select {
+		case <-fw.stopChan:
+			f.transition(fw, StateStopped, nil)
+			return
+		default:
+		}
+
+		if errors.Is(err, errInterrupted) {
+			f.reportChan <- fw.report(SeverityInfo, "reconnecting...")
+			continue
 		}
+
+		if err == nil {
+			err = errors.New("port forwarding finished unexpectedly")
+		}
+		f.reportChan <- fw.report(SeverityError, "port forwarding failed").WithErr(err)
+
+		if isPermanent(err) {
+			f.transition(fw, StateFailed, func(s *ForwardStatus) {
+				s.LastError = err
+			})
+			return
+		}
+
+		f.transition(fw, StateBackoff, func(s *ForwardStatus) {
+			s.LastError = err
+			s.Retries++
+		})
+		f.reportChan <- fw.report(SeverityTrace, "trying to restart forwarder in %s...", restartDelay)
+
+		select {
+		case <-fw.stopChan:
+			f.reportChan <- fw.report(SeverityInfo, "received stop signal, no more attempts to restart forwarder")
+			f.transition(fw, StateStopped, nil)
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// transition moves the forward into the given state and reports the change to the log and all observers.
+func (f *Forwarder) transition(fw *forward, state ForwardState, update func(*ForwardStatus)) {
+	if previous := fw.setState(state, update); previous != state {
+		f.reportChan <- fw.report(SeverityDebug, "%s -> %s", previous, state).WithEvent(EventState)
+
+		status := fw.Status()
+		for _, o := range f.observers {
+			o.StateChanged(status, previous)
+		}
+	}
+}
+
+// forwardSingle establishes a single port forwarding connection for the given forward,
+// it blocks until the connection is lost or the forward gets stopped.
+func (f *Forwarder) forwardSingle(fw *forward) error {
+	poder := fw.poder
+
+	port, err := f.listen(fw)
+	if err != nil {
+		return err
+	}
+
+	f.transition(fw, StateResolving, func(s *ForwardStatus) {
+		s.Pod = ""
+	})
+
+	fw.mu.Lock()
+	avoid := fw.avoidPod
+	fw.avoidPod = ""
+	fw.mu.Unlock()
+
+	started := time.Now()
+	pod, err := pickPod(poder, avoid)
+	if err != nil {
+		return fmt.Errorf("couldn't establish port forwarding -> %s", err)
+	}
+	took := time.Since(started)
+
+	f.transition(fw, StateConnecting, func(s *ForwardStatus) {
+		s.Pod = pod
+	})
+
+	status := fw.Status()
+	for _, o := range f.observers {
+		o.PodResolved(status, took)
+	}
+
+	f.reportChan <- fw.report(SeverityDebug, "establishing port forwarding for %s ...", pod)
+
+	tunnel, err := dialPod(f.k8sConfig, poder.Namespace(), pod)
+	if err != nil {
+		return fmt.Errorf("error forwarding ports: %w", err)
+	}
+	defer func() {
+		_ = tunnel.Close()
 	}()
 
-	for _, poder := range poders {
-		wg.Add(1)
-		if err := f.forwardSingle(&wg, poder, resultsChan, targetStopChan, reportChan); err != nil {
-			return doneChan, fmt.Errorf("error starting forwarder: %w", err)
+	// interrupts before the forward is ready are obsolete
+	select {
+	case <-fw.interruptChan:
+	default:
+	}
+
+	f.transition(fw, StateReady, func(s *ForwardStatus) {
+		if !s.ReadyAt.IsZero() {
+			s.Reconnects++
 		}
+		s.Retries = 0
+		s.LastError = nil
+		s.ReadyAt = time.Now()
+	})
+	fw.setTunnel(tunnel)
+	defer fw.setTunnel(nil)
+
+	f.reportChan <- fw.report(SeverityInfo, "Forwarding from %s -> %d", fw.localAddress(), port.Remote).WithEvent(EventState)
+
+	select {
+	case <-fw.stopChan:
+		return nil
+	case <-fw.interruptChan:
+		return errInterrupted
+	case <-tunnel.Done():
+		return errors.New("lost connection to pod")
 	}
+}
 
-	return doneChan, nil
+// listen binds the local port of the forward, unless it's already bound. The listeners
+// are kept until the forward is stopped, so the local port stays the same across reconnects.
+func (f *Forwarder) listen(fw *forward) (ForwardedPort, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if len(fw.status.Ports) > 0 {
+		return fw.status.Ports[0], nil
+	}
+
+	if fw.draining {
+		return ForwardedPort{}, errors.New("forward doesn't accept new connections")
+	}
+
+	port, err := ParsePorts(fw.resource.Ports)
+	if err != nil {
+		return ForwardedPort{}, permanentError{err}
+	}
+
+	listeners, err := f.listenFunc(fw.resource, port)
+	if err != nil {
+		return ForwardedPort{}, err
+	}
+	if addr, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+		// the port chosen by the system, or the one of a listener passed in
+		port.Local = uint16(addr.Port)
+	}
+
+	fw.listeners = listeners
+	fw.status.Ports = []ForwardedPort{port}
+	for _, l := range listeners {
+		fw.status.LocalAddresses = append(fw.status.LocalAddresses, l.Addr().String())
+	}
+
+	for _, l := range listeners {
+		go f.accept(fw, l)
+	}
+
+	return port, nil
+}
+
+// queueingListener is a listener whose connections queue up while the forward isn't ready,
+// instead of being accepted and closed, e.g. one passed by socket activation.
+type queueingListener interface {
+	net.Listener
+	queueUntilReady()
+}
+
+// accept handles all incoming connections of the listener until it's closed.
+func (f *Forwarder) accept(fw *forward, l net.Listener) {
+	_, queue := l.(queueingListener)
+	for {
+		if queue && !fw.waitReady() {
+			return
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				f.reportChan <- fw.report(SeverityError, "error accepting connection").WithEvent(EventConnection).WithErr(err)
+			}
+			return
+		}
+
+		go f.handleConnection(fw, conn)
+	}
+}
+
+// handleConnection forwards a single local connection to the pod.
+func (f *Forwarder) handleConnection(fw *forward, conn net.Conn) {
+	stats := ConnectionStats{
+		Client: conn.RemoteAddr().String(),
+		Local:  conn.LocalAddr().String(),
+		Start:  time.Now(),
+	}
+	var bytesIn, bytesOut atomic.Uint64
+	defer func() {
+		stats.End = time.Now()
+		stats.BytesIn, stats.BytesOut = bytesIn.Load(), bytesOut.Load()
+		f.connectionClosed(fw, stats)
+	}()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	tunnel, port, ok := fw.track(conn)
+	if !ok {
+		stats.Reason = CloseNotReady
+		f.reportChan <- fw.report(SeverityWarning, "closing connection from %s, forward is not ready", conn.RemoteAddr()).WithEvent(EventConnection)
+		return
+	}
+	defer fw.untrack(conn)
+	stats.Pod = tunnel.pod
+
+	f.reportChan <- fw.report(SeverityDebug, "handling connection from %s", conn.RemoteAddr()).WithEvent(EventConnection)
+
+	stream, err := tunnel.Dial(port.Remote)
+	if err != nil {
+		stats.Reason, stats.Err = CloseError, err
+		f.reportChan <- fw.report(SeverityError, "error handling connection from %s", conn.RemoteAddr()).WithEvent(EventConnection).WithErr(err)
+		return
+	}
+
+	var capture *captureStream
+	if f.capture != nil {
+		capture = f.capture.Stream(fw.resource.String(), conn.RemoteAddr(), fw.resource.Namespace, tunnel.pod, port.Remote)
+	}
+
+	localFirst, err := splice(conn, stream,
+		func(p []byte) {
+			bytesIn.Add(uint64(len(p)))
+			fw.bytesIn.Add(uint64(len(p)))
+			if capture != nil {
+				capture.FromPod(p)
+			}
+		},
+		func(p []byte) {
+			bytesOut.Add(uint64(len(p)))
+			fw.bytesOut.Add(uint64(len(p)))
+			if capture != nil {
+				capture.ToPod(p)
+			}
+		},
+	)
+	if closeErr := stream.Close(); closeErr != nil {
+		err = closeErr
+	}
+	stats.Err = err
+	if capture != nil {
+		capture.Close(localFirst)
+	}
+
+	switch {
+	case fw.isStopped():
+		stats.Reason = CloseStopped
+	case err != nil:
+		stats.Reason = CloseError
+	case localFirst:
+		stats.Reason = CloseClient
+	default:
+		stats.Reason = ClosePod
+	}
+
+	if err != nil {
+		f.reportChan <- fw.report(SeverityError, "error forwarding connection from %s", conn.RemoteAddr()).WithEvent(EventConnection).WithErr(err)
+	}
+}
+
+// connectionClosed reports the accounting of a finished connection to the log and all observers.
+func (f *Forwarder) connectionClosed(fw *forward, stats ConnectionStats) {
+	f.reportChan <- fw.report(SeverityDebug, "connection from %s closed (%s) after %s, %d bytes in, %d bytes out",
+		stats.Client, stats.Reason, stats.End.Sub(stats.Start).Round(time.Millisecond), stats.BytesIn, stats.BytesOut).WithEvent(EventConnection)
+
+	status := fw.Status()
+	for _, o := range f.observers {
+		o.ConnectionClosed(status, stats)
+	}
 }
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// newFakeAPIServer starts a k8s API server which knows a single pod ns/foo,
+// port forwarding connections to it are echoed back.
+func newFakeAPIServer(t *testing.T) *rest.Config {
+	t.Helper()
+
+	return newFakePodServer(t, func(stream io.ReadWriter) {
+		_, _ = io.Copy(stream, stream)
+	})
+}
+
+// newFakePodServer starts a k8s API server which knows a single pod ns/foo,
+// port forwarding connections to it are handled by serve.
+func newFakePodServer(t *testing.T, serve func(stream io.ReadWriter)) *rest.Config {
+	t.Helper()
+
+	return newFakeCluster(t, map[string]string{
+		"/api/v1/namespaces/ns/pods/foo": `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"foo","namespace":"ns"}}`,
+	}, func(_ string, stream io.ReadWriter) {
+		serve(stream)
+	})
+}
+
+// newFakeServiceServer starts a k8s API server which knows the pod ns/foo and the service
+// ns/web selecting it, which forwards port 80 to 8080 and 81 to the container port http,
+// 9090. Port forwarding connections to the pod are answered with their port.
+func newFakeServiceServer(t *testing.T) *rest.Config {
+	t.Helper()
+
+	return newFakeCluster(t, map[string]string{
+		"/api/v1/namespaces/ns/pods/foo": `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"foo","namespace":"ns","labels":{"app":"web"}},` +
+			`"spec":{"containers":[{"name":"web","ports":[{"name":"http","containerPort":9090}]}]}}`,
+		"/api/v1/namespaces/ns/pods": `{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"foo","namespace":"ns","labels":{"app":"web"}}}]}`,
+		"/api/v1/namespaces/ns/services/web": `{"kind":"Service","apiVersion":"v1","metadata":{"name":"web","namespace":"ns"},` +
+			`"spec":{"selector":{"app":"web"},"ports":[{"port":80,"targetPort":8080},{"port":81,"targetPort":"http"}]}}`,
+	}, func(port string, stream io.ReadWriter) {
+		_, _ = fmt.Fprintln(stream, port)
+	})
+}
+
+// newFakeCluster starts a k8s API server which serves the objects by path, port forwarding
+// connections to the pod ns/foo are handled by serve with the port they were opened to.
+func newFakeCluster(t *testing.T, objects map[string]string, serve func(port string, stream io.ReadWriter)) *rest.Config {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	for path, object := range objects {
+		mux.HandleFunc("GET "+path, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, object)
+		})
+	}
+	mux.HandleFunc("POST /api/v1/namespaces/ns/pods/foo/portforward", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := httpstream.Handshake(r, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+			return
+		}
+
+		conn := spdy.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, _ <-chan struct{}) error {
+			go func() {
+				defer func() {
+					_ = stream.Close()
+				}()
+				if stream.Headers().Get(v1.StreamType) == v1.StreamTypeData {
+					serve(stream.Headers().Get(v1.PortHeader), stream)
+				}
+			}()
+			return nil
+		})
+		if conn != nil {
+			<-conn.CloseChan()
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &rest.Config{Host: server.URL}
+}
+
+// drainReports discards all reports until the test is finished
+func drainReports(t *testing.T) chan Report {
+	t.Helper()
+
+	reportChan := make(chan Report, 100)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+	})
+
+	go func() {
+		for {
+			select {
+			case <-reportChan:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return reportChan
+}
+
+func waitForState(t *testing.T, forwarder *Forwarder, state ForwardState) ForwardStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, s := range forwarder.Status() {
+			if s.State == state {
+				return s
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("forward didn't reach state %s: %+v", state, forwarder.Status())
+	return ForwardStatus{}
+}
+
+func echo(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+
+	if _, err := fmt.Fprintln(conn, message); err != nil {
+		t.Fatalf("error writing to connection: %v", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("error reading from connection: %v", err)
+	}
+
+	if line != message+"\n" {
+		t.Fatalf("got %q, want %q", line, message+"\n")
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	status := waitForState(t, forwarder, StateReady)
+	if status.Pod != "foo" {
+		t.Errorf("got pod = %s, want foo", status.Pod)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", status.Ports[0].Local))
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	echo(t, conn, "hello")
+
+	if err := forwarder.Remove("ns/pod/foo"); err != nil {
+		t.Fatalf("Remove() returned an error: %v", err)
+	}
+
+	if got := len(forwarder.Status()); got != 0 {
+		t.Errorf("got %d forwards after Remove(), want 0", got)
+	}
+}
+
+func TestForwarderShutdown(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	status := waitForState(t, forwarder, StateReady)
+	address := fmt.Sprintf("127.0.0.1:%d", status.Ports[0].Local)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	echo(t, conn, "hello")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		forwarder.Shutdown(context.Background())
+	}()
+
+	// new connections are refused, while active ones keep working
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		_ = c.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("forward still accepts connections while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	echo(t, conn, "still there")
+
+	select {
+	case <-done:
+		t.Fatalf("Shutdown() returned while a connection was active")
+	default:
+	}
+
+	_ = conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown() didn't return after the connection was closed")
+	}
+
+	if got := waitForState(t, forwarder, StateStopped); got.ActiveConnections != 0 {
+		t.Errorf("got %d active connections, want 0", got.ActiveConnections)
+	}
+}
+
+func TestForwarderRepick(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	before := waitForState(t, forwarder, StateReady)
+
+	if err := forwarder.Repick("ns/pod/foo"); err != nil {
+		t.Fatalf("Repick() returned an error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := forwarder.Status()[0]
+		if status.State == StateReady && status.Reconnects == 1 {
+			// the local port is kept, foo is picked again since it's the only pod
+			if status.Ports[0] != before.Ports[0] || status.Pod != "foo" {
+				t.Errorf("got %+v, want the same port and pod as %+v", status, before)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("forward didn't reconnect: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recordingObserver records the states forwards went through and their connections
+type recordingObserver struct {
+	mu       sync.Mutex
+	states   []ForwardState
+	resolved int
+	conns    []ConnectionStats
+}
+
+func (o *recordingObserver) StateChanged(status ForwardStatus, _ ForwardState) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.states = append(o.states, status.State)
+}
+
+func (o *recordingObserver) PodResolved(_ ForwardStatus, _ time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.resolved++
+}
+
+func (o *recordingObserver) ConnectionClosed(_ ForwardStatus, conn ConnectionStats) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.conns = append(o.conns, conn)
+}
+
+func TestForwarderObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t), observer)
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", status.Ports[0].Local))
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	echo(t, conn, "hello")
+	_ = conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		observer.mu.Lock()
+		closed := len(observer.conns)
+		observer.mu.Unlock()
+		if closed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection wasn't reported as closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	forwarder.Stop()
+
+	if got := forwarder.Status()[0].Connections; got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+
+	c := observer.conns[0]
+	if c.Reason != CloseClient || c.Pod != "foo" || c.BytesIn != 6 || c.BytesOut != 6 || c.Err != nil {
+		t.Errorf("got connection %+v, want one closed by the client with 6 bytes each way to pod foo", c)
+	}
+	if c.Client != conn.LocalAddr().String() || c.End.Before(c.Start) {
+		t.Errorf("got client %s from %s to %s, want %s", c.Client, c.Start, c.End, conn.LocalAddr())
+	}
+
+	want := []ForwardState{StateConnecting, StateReady, StateStopped}
+	if !reflect.DeepEqual(observer.states, want) {
+		t.Errorf("got states %v, want %v", observer.states, want)
+	}
+	if observer.resolved != 1 {
+		t.Errorf("got %d pod resolutions, want 1", observer.resolved)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// gatewayDomain is the domain of the hosts the gateway routes by, e.g. web.payments.localhost.
+// Browsers resolve it to the loopback address without any setup.
+const gatewayDomain = "localhost"
+
+// gateway is an HTTP reverse proxy routing requests to the local addresses of forwards,
+// so every resource is reachable on a stable URL of a single port.
+type gateway struct {
+	forwarder  *Forwarder
+	namespace  string
+	reportChan chan<- Report
+	proxy      *httputil.ReverseProxy
+}
+
+// gatewayTarget is the forward a request is routed to, prefix is stripped from its path
+type gatewayTarget struct {
+	forward ForwardStatus
+	prefix  string
+}
+
+type gatewayTargetKey struct{}
+
+// newGatewayHandler returns the handler of the gateway. Requests are routed by the host, e.g.
+// web.payments.localhost or web.localhost for resources in namespace, or else by the first
+// segment of the path, e.g. /web.payments/index.html, which is stripped. WebSocket upgrades
+// are passed through.
+func newGatewayHandler(forwarder *Forwarder, namespace string, reportChan chan<- Report) http.Handler {
+	g := &gateway{
+		forwarder:  forwarder,
+		namespace:  namespace,
+		reportChan: reportChan,
+	}
+	g.proxy = &httputil.ReverseProxy{
+		Rewrite:      g.rewrite,
+		ErrorHandler: g.error,
+	}
+
+	return g
+}
+
+// serveGateway serves the gateway on addr in the background, requests are routed to the
+// local addresses of forwards as described by newGatewayHandler.
+func serveGateway(addr string, forwarder *Forwarder, namespace string, reportChan chan<- Report) (net.Listener, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	serveHTTP(l, newGatewayHandler(forwarder, namespace, reportChan), ComponentProxy, reportChan)
+
+	return l, nil
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := g.route(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no forward for %s%s", r.Host, r.URL.Path), http.StatusNotFound)
+		return
+	}
+
+	if target.forward.State != StateReady {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("forward %s is %s", target.forward.Resource.Key(), target.forward.State), http.StatusServiceUnavailable)
+		return
+	}
+
+	if target.prefix != "" && r.URL.Path == target.prefix {
+		// relative links of the index resolve below the prefix
+		http.Redirect(w, r, target.prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	g.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayTargetKey{}, target)))
+}
+
+// route returns the forward the request is routed to, by its host or else the first path segment
+func (g *gateway) route(r *http.Request) (gatewayTarget, bool) {
+	statuses := g.forwarder.Status()
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if name, ok := strings.CutSuffix(strings.ToLower(host), "."+gatewayDomain); ok {
+		if forward, ok := pickForward(matchForwards(statuses, name, g.namespace)); ok {
+			return gatewayTarget{forward: forward}, true
+		}
+		return gatewayTarget{}, false
+	}
+
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if segment == "" {
+		return gatewayTarget{}, false
+	}
+	if forward, ok := pickForward(matchForwards(statuses, segment, g.namespace)); ok {
+		return gatewayTarget{forward: forward, prefix: "/" + segment}, true
+	}
+
+	return gatewayTarget{}, false
+}
+
+// pickForward returns the first ready forward of a resource, or else the first one
+func pickForward(forwards []ForwardStatus) (ForwardStatus, bool) {
+	for _, f := range forwards {
+		if f.State == StateReady {
+			return f, true
+		}
+	}
+	if len(forwards) == 0 {
+		return ForwardStatus{}, false
+	}
+
+	return forwards[0], true
+}
+
+func (g *gateway) rewrite(r *httputil.ProxyRequest) {
+	target := r.In.Context().Value(gatewayTargetKey{}).(gatewayTarget)
+
+	if target.prefix != "" {
+		r.Out.URL.Path = strings.TrimPrefix(r.Out.URL.Path, target.prefix)
+		r.Out.URL.RawPath = strings.TrimPrefix(r.Out.URL.RawPath, target.prefix)
+		r.Out.Header.Set("X-Forwarded-Prefix", target.prefix)
+	}
+
+	r.SetURL(&url.URL{Scheme: "http", Host: target.forward.LocalAddresses[0]})
+	r.SetXForwarded()
+}
+
+func (g *gateway) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client went away
+		return
+	}
+
+	target := r.Context().Value(gatewayTargetKey{}).(gatewayTarget)
+	g.reportChan <- NewReport(SeverityError, nil, "error proxying %s %s%s to %s", r.Method, r.Host, r.URL.Path, target.forward.Resource.Key()).WithComponent(ComponentProxy).WithErr(err)
+
+	http.Error(w, fmt.Sprintf("error proxying to %s", target.forward.Resource.Key()), http.StatusBadGateway)
+}
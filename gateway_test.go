@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// connListener is a listener accepting the connections sent to conns
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	close(l.done)
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// newFakeHTTPPod starts a k8s API server with the pod ns/foo serving handler on all ports
+func newFakeHTTPPod(t *testing.T, handler http.Handler) *Forwarder {
+	t.Helper()
+
+	l := &connListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	server := &http.Server{Handler: handler}
+	go func() {
+		_ = server.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	config := newFakePodServer(t, func(stream io.ReadWriter) {
+		client, conn := net.Pipe()
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			return
+		}
+		go func() {
+			_, _ = io.Copy(client, stream)
+			_ = client.Close()
+		}()
+		_, _ = io.Copy(stream, client)
+	})
+
+	forwarder := NewForwarder(config, drainReports(t))
+	t.Cleanup(forwarder.Stop)
+
+	return forwarder
+}
+
+func TestGateway(t *testing.T) {
+	forwarder := newFakeHTTPPod(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			conn, rw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			_ = rw.Flush()
+			_, _ = io.Copy(conn, rw)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s", r.URL.Path, r.Header.Get("X-Forwarded-Prefix"))
+	}))
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	waitForState(t, forwarder, StateReady)
+
+	server := httptest.NewServer(newGatewayHandler(forwarder, "ns", drainReports(t)))
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	tests := []struct {
+		host, path string
+		wantStatus int
+		wantBody   string
+	}{
+		{host: "foo.ns.localhost", path: "/bar", wantStatus: http.StatusOK, wantBody: "/bar "},
+		{host: "foo.localhost:8000", path: "/", wantStatus: http.StatusOK, wantBody: "/ "},
+		{path: "/foo.ns/bar/baz", wantStatus: http.StatusOK, wantBody: "/bar/baz /foo.ns"},
+		{path: "/foo/", wantStatus: http.StatusOK, wantBody: "/ /foo"},
+		{path: "/foo", wantStatus: http.StatusMovedPermanently},
+		{host: "bar.ns.localhost", path: "/", wantStatus: http.StatusNotFound},
+		{path: "/bar.ns/", wantStatus: http.StatusNotFound},
+		{path: "/", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("websocket", func(t *testing.T) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		_, _ = fmt.Fprint(conn, "GET /chat HTTP/1.1\r\nHost: foo.ns.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+
+		_, _ = fmt.Fprintln(conn, "hello")
+		line, err := reader.ReadString('\n')
+		if err != nil || line != "hello\n" {
+			t.Errorf("got %q (%v), want the message echoed", line, err)
+		}
+	})
+}
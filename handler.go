@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// attribute keys of reports
+const (
+	EventKey     = "event"
+	ComponentKey = "component"
+	ResourceKey  = "resource"
+	NamespaceKey = "namespace"
+	PodKey       = "pod"
+	PortsKey     = "ports"
+	ErrorKey     = "error"
+)
+
+const Reset = "\033[0m"
+const Red = "\033[31m"
+const Green = "\033[32m"
+const Yellow = "\033[33m"
+const Cyan = "\033[36m"
+
+// consoleTimeFormat is the format of timestamps written by the ConsoleHandler
+const consoleTimeFormat = "2006-01-02 15:04:05.000"
+
+// ConsoleHandlerOptions are options for a ConsoleHandler
+type ConsoleHandlerOptions struct {
+	// Level is the minimum level of records to be written, defaults to slog.LevelInfo
+	Level slog.Leveler
+	// OutColor and ErrColor enable ANSI colors for the respective writer
+	OutColor bool
+	ErrColor bool
+	// Timestamps prefixes every line with the time of the record
+	Timestamps bool
+}
+
+// ConsoleHandler is a slog.Handler which writes human-readable, optionally colored lines,
+// errors are written to a separate writer.
+type ConsoleHandler struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	errOut io.Writer
+	opts   ConsoleHandlerOptions
+	attrs  []slog.Attr
+	group  string
+}
+
+var _ slog.Handler = &ConsoleHandler{}
+
+func NewConsoleHandler(out, errOut io.Writer, opts *ConsoleHandlerOptions) *ConsoleHandler {
+	h := &ConsoleHandler{
+		mu:     &sync.Mutex{},
+		out:    out,
+		errOut: errOut,
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+
+	return h
+}
+
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+// Handle writes the record as "[SEVERITY] [resource] message: error key=value ..."
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var resource, errMsg string
+	var extra []string
+
+	appendAttr := func(a slog.Attr) {
+		switch {
+		case h.group == "" && a.Key == ResourceKey:
+			resource = a.Value.String()
+		case h.group == "" && a.Key == ErrorKey:
+			errMsg = a.Value.String()
+		case h.group == "" && (a.Key == EventKey || a.Key == ComponentKey || a.Key == NamespaceKey || a.Key == PodKey || a.Key == PortsKey):
+			// already part of the resource or not meant for humans
+		default:
+			key := a.Key
+			if h.group != "" {
+				key = h.group + "." + key
+			}
+			extra = append(extra, fmt.Sprintf("%s=%v", key, a.Value))
+		}
+	}
+
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(a)
+		return true
+	})
+
+	severity := SeverityFromLevel(r.Level)
+	out, colored, color := h.out, h.opts.OutColor, Cyan
+	switch severity {
+	case SeverityInfo:
+		color = Green
+	case SeverityWarning:
+		color = Yellow
+	case SeverityError:
+		out, colored, color = h.errOut, h.opts.ErrColor, Red
+	}
+
+	paint := func(color, s string) string {
+		if !colored {
+			return s
+		}
+		return color + s + Reset
+	}
+
+	var sb strings.Builder
+	if h.opts.Timestamps && !r.Time.IsZero() {
+		sb.WriteString(r.Time.Format(consoleTimeFormat) + " ")
+	}
+	sb.WriteString(paint(color, fmt.Sprintf("[%s]", severity)) + " ")
+	if resource != "" {
+		sb.WriteString(paint(resourceColor(resource), fmt.Sprintf("[%s]", resource)) + " ")
+	}
+
+	message := r.Message
+	if errMsg != "" {
+		message += ": " + errMsg
+	}
+	for _, e := range extra {
+		message += " " + e
+	}
+	sb.WriteString(paint(color, message))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := fmt.Fprintln(out, sb.String())
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return &h2
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	if h2.group != "" {
+		name = h2.group + "." + name
+	}
+	h2.group = name
+	return &h2
+}
+
+// NewJSONHandler returns a slog.JSONHandler which writes reports using the
+// multiforward severity names and "message" as message key.
+func NewJSONHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+
+			switch a.Key {
+			case slog.LevelKey:
+				if level, ok := a.Value.Any().(slog.Level); ok {
+					return slog.String("severity", SeverityFromLevel(level).String())
+				}
+			case slog.MessageKey:
+				a.Key = "message"
+			}
+
+			return a
+		},
+	})
+}
+
+// ComponentLevelHandler filters records by a minimum level per component,
+// records of other components are filtered by the default level.
+type ComponentLevelHandler struct {
+	next      slog.Handler
+	level     slog.Leveler
+	levels    map[Component]slog.Level
+	component Component
+}
+
+var _ slog.Handler = &ComponentLevelHandler{}
+
+func NewComponentLevelHandler(next slog.Handler, level slog.Leveler, levels map[Component]slog.Level) *ComponentLevelHandler {
+	return &ComponentLevelHandler{
+		next:   next,
+		level:  level,
+		levels: levels,
+	}
+}
+
+// minLevel returns the lowest level enabled for any component
+func (h *ComponentLevelHandler) minLevel() slog.Level {
+	if h.component != "" {
+		return h.levelOf(h.component)
+	}
+
+	lowest := h.level.Level()
+	for _, l := range h.levels {
+		if l < lowest {
+			lowest = l
+		}
+	}
+
+	return lowest
+}
+
+func (h *ComponentLevelHandler) levelOf(component Component) slog.Level {
+	if l, ok := h.levels[component]; ok {
+		return l
+	}
+
+	return h.level.Level()
+}
+
+func (h *ComponentLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.minLevel() && h.next.Enabled(ctx, level)
+}
+
+func (h *ComponentLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	component := h.component
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == ComponentKey {
+			component = Component(a.Value.String())
+			return false
+		}
+		return true
+	})
+
+	if r.Level < h.levelOf(component) {
+		return nil
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *ComponentLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			h2.component = Component(a.Value.String())
+		}
+	}
+	h2.next = h.next.WithAttrs(attrs)
+	return &h2
+}
+
+func (h *ComponentLevelHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h.next.WithGroup(name)
+	return &h2
+}
+
+// ParseComponentLevels parses severities by component name, e.g. {"config": "debug"}
+func ParseComponentLevels(severities map[string]string) (map[Component]slog.Level, error) {
+	levels := make(map[Component]slog.Level, len(severities))
+	for component, s := range severities {
+		severity, err := SeverityFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid severity for component %s: %w", component, err)
+		}
+		levels[Component(component)] = severity.Level()
+	}
+
+	return levels, nil
+}
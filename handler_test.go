@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestConsoleHandler(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger := slog.New(NewConsoleHandler(&out, &errOut, &ConsoleHandlerOptions{
+		Level:    slog.LevelDebug,
+		OutColor: true,
+		ErrColor: true,
+	}))
+	poder := podPoder{namespace: "ns", pod: "foo", ports: []string{"8080:80"}}
+
+	NewReport(SeverityTrace, poder, "not logged").Log(logger)
+	NewReport(SeverityInfo, poder, "ready").WithPod("foo").Log(logger)
+	NewReport(SeverityError, nil, "failed").WithErr(fmt.Errorf("boom")).Log(logger)
+	logger.Debug("plain", "key", "value")
+
+	wantOut := Green + "[INFO]" + Reset + " " + resourceColor("ns/pod/foo") + "[ns/pod/foo]" + Reset + " " + Green + "ready" + Reset + "\n" +
+		Cyan + "[DEBUG]" + Reset + " " + Cyan + "plain key=value" + Reset + "\n"
+	if out.String() != wantOut {
+		t.Errorf("got out = %q, want %q", out.String(), wantOut)
+	}
+
+	wantErrOut := Red + "[ERROR]" + Reset + " " + Red + "failed: boom" + Reset + "\n"
+	if errOut.String() != wantErrOut {
+		t.Errorf("got errOut = %q, want %q", errOut.String(), wantErrOut)
+	}
+}
+
+func TestConsoleHandlerWithoutColor(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger := slog.New(NewConsoleHandler(&out, &errOut, &ConsoleHandlerOptions{
+		OutColor:   false,
+		ErrColor:   true,
+		Timestamps: true,
+	}))
+	poder := podPoder{namespace: "ns", pod: "foo", ports: []string{"8080:80"}}
+
+	report := NewReport(SeverityInfo, poder, "ready")
+	report.Time = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	report.Log(logger)
+
+	report = NewReport(SeverityError, poder, "failed")
+	report.Time = time.Date(2024, 1, 1, 12, 0, 1, 0, time.UTC)
+	report.Log(logger)
+
+	if want := "2024-01-01 12:00:00.000 [INFO] [ns/pod/foo] ready\n"; out.String() != want {
+		t.Errorf("got out = %q, want %q", out.String(), want)
+	}
+
+	wantErrOut := "2024-01-01 12:00:01.000 " + Red + "[ERROR]" + Reset + " " + resourceColor("ns/pod/foo") + "[ns/pod/foo]" + Reset + " " + Red + "failed" + Reset + "\n"
+	if errOut.String() != wantErrOut {
+		t.Errorf("got errOut = %q, want %q", errOut.String(), wantErrOut)
+	}
+}
+
+func TestJSONHandler(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(NewJSONHandler(&out, LevelTrace))
+	poder := servicePoder{namespace: "ns", service: "foo", ports: []string{"8080:80"}}
+
+	report := NewReport(SeverityError, poder, "port forwarding failed").
+		WithEvent(EventState).
+		WithComponent(ComponentForwarder).
+		WithPod("foo-abc").
+		WithErr(fmt.Errorf("lost connection to pod"))
+	report.Time = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	report.Log(logger)
+
+	want := `{"time":"2024-01-01T12:00:00Z","severity":"ERROR","message":"port forwarding failed","event":"state","component":"forwarder","resource":"ns/service/foo","namespace":"ns","pod":"foo-abc","ports":["8080:80"],"error":"lost connection to pod"}` + "\n"
+	if out.String() != want {
+		t.Errorf("got %s, want %s", out.String(), want)
+	}
+}
+
+func TestComponentLevelHandler(t *testing.T) {
+	var out bytes.Buffer
+	levels := map[Component]slog.Level{ComponentConfig: slog.LevelDebug, ComponentForwarder: slog.LevelError}
+	handler := NewConsoleHandler(&out, &out, &ConsoleHandlerOptions{Level: LevelTrace})
+	logger := slog.New(NewComponentLevelHandler(handler, slog.LevelInfo, levels))
+
+	NewReport(SeverityDebug, nil, "config debug").WithComponent(ComponentConfig).Log(logger)
+	NewReport(SeverityWarning, nil, "forwarder warning").WithComponent(ComponentForwarder).Log(logger)
+	NewReport(SeverityDebug, nil, "main debug").Log(logger)
+	NewReport(SeverityInfo, nil, "main info").Log(logger)
+	logger.With(ComponentKey, string(ComponentConfig)).Debug("scoped config debug")
+
+	want := "[DEBUG] config debug\n" +
+		"[INFO] main info\n" +
+		"[DEBUG] scoped config debug\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HookEvent is an event of the forward lifecycle a hook can be run for
+type HookEvent string
+
+const (
+	// HookReady is triggered when a forward becomes ready for the first time
+	HookReady HookEvent = "ready"
+	// HookReconnect is triggered when a forward becomes ready again, e.g. after the connection was lost
+	HookReconnect HookEvent = "reconnect"
+	// HookPodChange is triggered when a forward becomes ready with another pod than before
+	HookPodChange HookEvent = "pod_change"
+	// HookFail is triggered when a forward failed, once until it's ready again, and when it's given up
+	HookFail HookEvent = "fail"
+)
+
+// hookTimeout is the time a hook may run before it's killed
+const hookTimeout = 30 * time.Second
+
+// Hooks are shell commands run on events of a forward, empty commands are skipped
+type Hooks struct {
+	OnReady     string `json:"onReady,omitempty"`
+	OnReconnect string `json:"onReconnect,omitempty"`
+	OnPodChange string `json:"onPodChange,omitempty"`
+	OnFail      string `json:"onFail,omitempty"`
+}
+
+// Command returns the command to run for the given event
+func (h Hooks) Command(event HookEvent) string {
+	switch event {
+	case HookReady:
+		return h.OnReady
+	case HookReconnect:
+		return h.OnReconnect
+	case HookPodChange:
+		return h.OnPodChange
+	case HookFail:
+		return h.OnFail
+	default:
+		return ""
+	}
+}
+
+// Or returns the hooks with every empty command replaced by the one of defaults
+func (h Hooks) Or(defaults Hooks) Hooks {
+	or := func(s, def string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	}
+
+	return Hooks{
+		OnReady:     or(h.OnReady, defaults.OnReady),
+		OnReconnect: or(h.OnReconnect, defaults.OnReconnect),
+		OnPodChange: or(h.OnPodChange, defaults.OnPodChange),
+		OnFail:      or(h.OnFail, defaults.OnFail),
+	}
+}
+
+// HookRunner runs the hooks of forwards on their lifecycle events, it's an Observer of the Forwarder.
+// The commands are run by the shell in the background, with the event described by environment variables.
+type HookRunner struct {
+	global     Hooks
+	reportChan chan<- Report
+
+	mu sync.Mutex
+	// forwards are the hooks by forward ID, they take precedence over the global ones
+	forwards map[string]Hooks
+	// pods are the pods forwards were ready with the last time, by forward ID
+	pods map[string]string
+	// failing are the forwards which failed and weren't ready since, by forward ID
+	failing map[string]bool
+	// wg tracks running hooks
+	wg sync.WaitGroup
+}
+
+var _ Observer = &HookRunner{}
+
+func NewHookRunner(global Hooks, reportChan chan<- Report) *HookRunner {
+	return &HookRunner{
+		global:     global,
+		reportChan: reportChan,
+		forwards:   make(map[string]Hooks),
+		pods:       make(map[string]string),
+		failing:    make(map[string]bool),
+	}
+}
+
+// SetHooks replaces the hooks of single forwards, by forward ID
+func (h *HookRunner) SetHooks(forwards map[string]Hooks) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.forwards = forwards
+}
+
+func (h *HookRunner) StateChanged(status ForwardStatus, _ ForwardState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var events []HookEvent
+	previousPod, seen := h.pods[status.ID]
+
+	switch status.State {
+	case StateReady:
+		h.pods[status.ID] = status.Pod
+		delete(h.failing, status.ID)
+		if status.Reconnects == 0 {
+			events = append(events, HookReady)
+		} else {
+			events = append(events, HookReconnect)
+		}
+		if seen && previousPod != status.Pod {
+			events = append(events, HookPodChange)
+		}
+	case StateBackoff:
+		// retries of a forward which is down are a single failure
+		if !h.failing[status.ID] {
+			h.failing[status.ID] = true
+			events = append(events, HookFail)
+		}
+	case StateFailed:
+		h.failing[status.ID] = true
+		events = append(events, HookFail)
+	case StateStopped:
+		delete(h.pods, status.ID)
+		delete(h.failing, status.ID)
+	}
+
+	hooks := h.forwards[status.ID].Or(h.global)
+
+	var commands []hookCommand
+	for _, event := range events {
+		if cmd := hooks.Command(event); cmd != "" {
+			commands = append(commands, hookCommand{event: event, command: cmd, env: hookEnv(event, status, previousPod)})
+		}
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	// hooks of a single state change are run in order
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for _, c := range commands {
+			h.run(status.Resource, c)
+		}
+	}()
+}
+
+func (h *HookRunner) PodResolved(ForwardStatus, time.Duration) {}
+
+func (h *HookRunner) ConnectionClosed(ForwardStatus, ConnectionStats) {}
+
+// Wait waits until all running hooks are finished or ctx is done
+func (h *HookRunner) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hookCommand is a hook to be run
+type hookCommand struct {
+	event   HookEvent
+	command string
+	env     []string
+}
+
+func (h *HookRunner) run(resource Resource, c hookCommand) {
+	report := func(severity Severity, format string, a ...any) Report {
+		return NewReport(severity, nil, format, a...).WithComponent(ComponentHooks).WithResource(resource)
+	}
+
+	h.reportChan <- report(SeverityDebug, "running %s hook: %s", c.event, c.command)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, c.command)
+	cmd.Env = append(os.Environ(), c.env...)
+	// processes started by the shell may keep the output open after it's killed
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(output))
+	if err != nil {
+		h.reportChan <- report(SeverityWarning, "%s hook failed, output: %q", c.event, out).WithErr(err)
+		return
+	}
+
+	h.reportChan <- report(SeverityDebug, "%s hook finished, output: %q", c.event, out)
+}
+
+// shellCommand returns a command running the given command line by the shell, it's killed once ctx is done
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// hookEnv returns the environment variables describing the event
+func hookEnv(event HookEvent, status ForwardStatus, previousPod string) []string {
+	var localPort, remotePort, lastErr string
+	if len(status.Ports) > 0 {
+		localPort = strconv.Itoa(int(status.Ports[0].Local))
+		remotePort = strconv.Itoa(int(status.Ports[0].Remote))
+	}
+	if status.LastError != nil {
+		lastErr = status.LastError.Error()
+	}
+
+	vars := map[string]string{
+		"EVENT":        string(event),
+		"ID":           status.ID,
+		"RESOURCE":     status.Resource.Key(),
+		"NAMESPACE":    status.Resource.Namespace,
+		"POD":          status.Pod,
+		"PREVIOUS_POD": previousPod,
+		"LOCAL_PORT":   localPort,
+		"REMOTE_PORT":  remotePort,
+		"ERROR":        lastErr,
+	}
+
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, fmt.Sprintf("MULTIFORWARD_%s=%s", name, value))
+	}
+
+	return env
+}
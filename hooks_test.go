@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run by sh")
+	}
+
+	out := filepath.Join(t.TempDir(), "events")
+	record := `echo "$MULTIFORWARD_EVENT $MULTIFORWARD_RESOURCE $MULTIFORWARD_POD $MULTIFORWARD_PREVIOUS_POD $MULTIFORWARD_LOCAL_PORT:$MULTIFORWARD_REMOTE_PORT $MULTIFORWARD_ERROR" >> ` + out
+
+	runner := NewHookRunner(Hooks{OnReady: record, OnReconnect: record, OnPodChange: record, OnFail: record}, drainReports(t))
+
+	foo := ForwardStatus{
+		ID:       "ns/service/foo:8080:80",
+		Resource: Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+		Ports:    []ForwardedPort{{Local: 8080, Remote: 80}},
+	}
+	bar := ForwardStatus{
+		ID:       "ns/pod/bar:9090:9090",
+		Resource: Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"},
+		Ports:    []ForwardedPort{{Local: 9090, Remote: 9090}},
+	}
+	// bar only runs its own fail hook
+	runner.SetHooks(map[string]Hooks{bar.ID: {OnReady: "true", OnFail: `echo "bar failed" >> ` + out}})
+
+	change := func(s ForwardStatus, state ForwardState, update func(*ForwardStatus)) {
+		s.State = state
+		if update != nil {
+			update(&s)
+		}
+		runner.StateChanged(s, StateConnecting)
+		if err := runner.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	change(foo, StateReady, func(s *ForwardStatus) { s.Pod = "foo-1" })
+	change(foo, StateBackoff, func(s *ForwardStatus) { s.Pod = "foo-1"; s.LastError = errors.New("lost connection") })
+	// retries of the same failure
+	change(foo, StateConnecting, nil)
+	change(foo, StateBackoff, func(s *ForwardStatus) { s.LastError = errors.New("pod not found") })
+	change(foo, StateReady, func(s *ForwardStatus) { s.Pod = "foo-1"; s.Reconnects = 1 })
+	change(foo, StateReady, func(s *ForwardStatus) { s.Pod = "foo-2"; s.Reconnects = 2 })
+	change(foo, StateStopped, nil)
+	change(bar, StateReady, func(s *ForwardStatus) { s.Pod = "bar" })
+	change(bar, StateBackoff, func(s *ForwardStatus) { s.LastError = errors.New("lost connection") })
+	change(bar, StateFailed, func(s *ForwardStatus) { s.LastError = errors.New("invalid port") })
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("error reading hook output: %v", err)
+	}
+
+	want := []string{
+		"ready ns/service/foo foo-1  8080:80 ",
+		"fail ns/service/foo foo-1 foo-1 8080:80 lost connection",
+		"reconnect ns/service/foo foo-1 foo-1 8080:80 ",
+		"reconnect ns/service/foo foo-2 foo-1 8080:80 ",
+		"pod_change ns/service/foo foo-2 foo-1 8080:80 ",
+		"bar failed",
+		"bar failed",
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hooks recorded:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHooksOr(t *testing.T) {
+	got := Hooks{OnReady: "a"}.Or(Hooks{OnReady: "b", OnFail: "c"})
+	if want := (Hooks{OnReady: "a", OnFail: "c"}); got != want {
+		t.Errorf("Or() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHookRunnerTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run by sh")
+	}
+
+	runner := NewHookRunner(Hooks{OnReady: "sleep 60"}, drainReports(t))
+	runner.StateChanged(ForwardStatus{ID: "ns/pod/foo:0:80", State: StateReady}, StateConnecting)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := runner.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
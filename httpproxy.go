@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// httpProxy is an HTTP proxy tunneling CONNECT requests to names of resources, e.g.
+// api.payments:80, to a pod of the resource over a tunnel of the pool.
+type httpProxy struct {
+	pool       *TunnelPool
+	reportChan chan<- Report
+}
+
+// serveHTTPProxy serves the HTTP proxy on addr in the background, CONNECT requests are
+// tunneled to pods over the pool, other methods are rejected.
+func serveHTTPProxy(addr string, pool *TunnelPool, reportChan chan<- Report) (net.Listener, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	serveHTTP(l, &httpProxy{pool: pool, reportChan: reportChan}, ComponentProxy, reportChan)
+
+	return l, nil
+}
+
+func (h *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		// plain requests would have to be proxied per request, clients tunnel them by CONNECT, e.g. curl --proxytunnel
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, portString, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target %s: %s", r.Host, err), http.StatusBadRequest)
+		return
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid port %s", portString), http.StatusBadRequest)
+		return
+	}
+
+	stream, err := h.pool.Dial(host, uint16(port))
+	if err != nil {
+		h.reportChan <- NewReport(SeverityError, nil, "error connecting %s to %s", r.RemoteAddr, r.Host).WithComponent(ComponentProxy).WithErr(err)
+		http.Error(w, fmt.Sprintf("error connecting to %s: %s", r.Host, err), http.StatusBadGateway)
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		_ = stream.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		_ = stream.Close()
+		_ = conn.Close()
+		return
+	}
+
+	h.reportChan <- NewReport(SeverityDebug, nil, "connected %s to %s (%s)", conn.RemoteAddr(), r.Host, stream.Target).WithComponent(ComponentProxy)
+	proxyConnection(&bufferedConn{Conn: conn, reader: rw.Reader}, stream, r.Host, h.reportChan)
+}
+
+// bufferedConn is a hijacked connection, data the client sent right after the request
+// may be buffered by the server already
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
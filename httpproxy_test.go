@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	pool := NewTunnelPool(newFakeAPIServer(t), "ns")
+	defer pool.Close()
+
+	l, err := serveHTTPProxy("127.0.0.1:0", pool, drainReports(t))
+	if err != nil {
+		t.Fatalf("serveHTTPProxy() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	// connect sends a CONNECT request for target followed by message, which is sent before the response is read
+	connect := func(t *testing.T, target, message string) (*http.Response, *bufio.Reader) {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		_, _ = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n%s", target, target, message)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+
+		return resp, reader
+	}
+
+	// the pod of a headless service is named by its hostname
+	for _, target := range []string{"foo.ns:80", "foo:8080", "foo.sub.ns.svc.cluster.local:80"} {
+		t.Run(target, func(t *testing.T) {
+			resp, reader := connect(t, target, "hello\n")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			line, err := reader.ReadString('\n')
+			if err != nil || line != "hello\n" {
+				t.Errorf("got %q (%v), want the message echoed", line, err)
+			}
+		})
+	}
+
+	if got := pool.Tunnels(); got != 1 {
+		t.Errorf("expected 1 tunnel, got %d", got)
+	}
+
+	if resp, _ := connect(t, "bar.ns:80", ""); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("got status %d for an unknown resource, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/", l.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for GET, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPProxyTargetPort(t *testing.T) {
+	pool := NewTunnelPool(newFakeServiceServer(t), "ns")
+	defer pool.Close()
+
+	l, err := serveHTTPProxy("127.0.0.1:0", pool, drainReports(t))
+	if err != nil {
+		t.Fatalf("serveHTTPProxy() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	// the fake pod answers with the port the connection was forwarded to
+	for target, want := range map[string]string{"web.ns:80": "8080\n", "web:81": "9090\n"} {
+		t.Run(target, func(t *testing.T) {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			_, _ = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatalf("error reading response: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			line, err := reader.ReadString('\n')
+			if err != nil || line != want {
+				t.Errorf("got %q (%v), want %q", line, err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hostsMarker marks the begin and end of the block of an instance in the hosts file
+const hostsMarker = "kubectl-multiforward"
+
+// hostsLockTimeout is the time to wait for other instances updating the hosts file
+const hostsLockTimeout = 5 * time.Second
+
+// defaultHostsFile returns the path of the system's hosts file
+func defaultHostsFile() string {
+	if runtime.GOOS == "windows" {
+		return `C:\Windows\System32\drivers\etc\hosts`
+	}
+
+	return "/etc/hosts"
+}
+
+// LoopbackAllocator assigns every resource a loopback address of its own and binds the
+// remote ports of its forwards on it, so forwards of several resources can use the same
+// port, e.g. 127.1.0.1:5432 and 127.1.0.2:5432.
+type LoopbackAllocator struct {
+	network *net.IPNet
+
+	mu sync.Mutex
+	// assigned are the addresses by resource key, they are kept for the lifetime of the process
+	assigned map[string]net.IP
+	used     map[string]bool
+}
+
+// NewLoopbackAllocator allocates addresses of the given network, e.g. 127.1.0.0/24
+func NewLoopbackAllocator(cidr string) (*LoopbackAllocator, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("%s is not an IPv4 loopback network", cidr)
+	}
+
+	return &LoopbackAllocator{
+		network:  network,
+		assigned: make(map[string]net.IP),
+		used:     make(map[string]bool),
+	}, nil
+}
+
+// Contains returns true if ip is allocated from the network of the allocator
+func (a *LoopbackAllocator) Contains(ip net.IP) bool {
+	return a.network.Contains(ip)
+}
+
+// Listen is the ListenFunc of the forwarder, it binds the remote port on the address of the
+// resource. Addresses bound by others, e.g. another instance, are skipped on allocation.
+func (a *LoopbackAllocator) Listen(resource Resource, port ForwardedPort) ([]net.Listener, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	listen := func(ip net.IP) (net.Listener, error) {
+		return net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port.Remote))))
+	}
+
+	if ip, ok := a.assigned[resource.Key()]; ok {
+		l, err := listen(ip)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	for ip := nextIP(a.network.IP); a.network.Contains(ip); ip = nextIP(ip) {
+		if a.used[ip.String()] || isBroadcast(ip, a.network) {
+			continue
+		}
+
+		l, err := listen(ip)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		a.assigned[resource.Key()] = ip
+		a.used[ip.String()] = true
+		return []net.Listener{l}, nil
+	}
+
+	return nil, fmt.Errorf("no free address in %s for port %d", a.network, port.Remote)
+}
+
+// nextIP returns the IPv4 address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, net.IPv4len)
+	copy(next, ip.To4())
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// isBroadcast returns true if ip is the last address of network
+func isBroadcast(ip net.IP, network *net.IPNet) bool {
+	ip = ip.To4()
+	for i, b := range ip {
+		if b|network.Mask[i] != 0xff {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hostNames returns the names a resource is resolved by in the cluster, e.g. api, api.payments
+// and api.payments.svc.cluster.local for services
+func hostNames(r Resource) []string {
+	names := []string{r.Name, r.Name + "." + r.Namespace}
+	if r.Type == Service {
+		names = append(names, r.Name+"."+r.Namespace+".svc.cluster.local")
+	}
+
+	return names
+}
+
+// HostsFile maintains a block in the hosts file mapping the names of forwards to their
+// loopback addresses, whenever they change. It's an Observer of the Forwarder.
+//
+// The block is marked by the pid of this instance, blocks of processes which aren't
+// running anymore are removed by Cleanup, e.g. after a crash.
+type HostsFile struct {
+	path string
+	// lockDir is the directory of the lock file shared with other instances, none is taken if empty
+	lockDir    string
+	allocator  *LoopbackAllocator
+	reportChan chan<- Report
+	changed    chan struct{}
+
+	mu sync.Mutex
+	// last is the last written block, it's only written if it changed
+	last []byte
+	// removed is set once the block was removed for good
+	removed bool
+}
+
+var _ Observer = &HostsFile{}
+
+func NewHostsFile(path, lockDir string, allocator *LoopbackAllocator, reportChan chan<- Report) *HostsFile {
+	return &HostsFile{
+		path:       path,
+		lockDir:    lockDir,
+		allocator:  allocator,
+		reportChan: reportChan,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+func (h *HostsFile) StateChanged(ForwardStatus, ForwardState) {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+		// already pending
+	}
+}
+
+func (h *HostsFile) PodResolved(ForwardStatus, time.Duration) {}
+
+func (h *HostsFile) ConnectionClosed(ForwardStatus, ConnectionStats) {}
+
+// Run updates the hosts file whenever a forward changed, until stopChan is closed.
+func (h *HostsFile) Run(forwarder *Forwarder, stopChan <-chan struct{}) {
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-h.changed:
+		}
+
+		if err := h.Update(forwarder.Status()); err != nil {
+			h.reportChan <- NewReport(SeverityError, nil, "error updating hosts file %s", h.path).WithComponent(ComponentHosts).WithErr(err)
+		}
+	}
+}
+
+// Cleanup removes the block of this instance and the ones of instances which aren't running anymore
+func (h *HostsFile) Cleanup() error {
+	return h.write(nil, false)
+}
+
+// Remove removes the block of this instance, it isn't written again afterward
+func (h *HostsFile) Remove() error {
+	return h.write(nil, true)
+}
+
+// Update maps the names of all forwards which are bound on an allocated address
+func (h *HostsFile) Update(statuses []ForwardStatus) error {
+	return h.write(hostsBlock(statuses, h.allocator), false)
+}
+
+// write replaces the block of this instance, if final it's the last write
+func (h *HostsFile) write(block []byte, final bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.removed {
+		return nil
+	}
+	// set within the final write, so no update can write the block again
+	h.removed = final
+
+	if h.last != nil && bytes.Equal(block, h.last) {
+		return nil
+	}
+
+	// other instances update their blocks of the same file
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	content, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+
+	updated := updateHostsBlock(content, os.Getpid(), block, processAlive)
+	if !bytes.Equal(updated, content) {
+		// the hosts file may be a mount point which can't be replaced, e.g. in containers
+		if err := writeFileAtomic(h.path, updated); err != nil {
+			if err := os.WriteFile(h.path, updated, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	h.last = block
+	return nil
+}
+
+// lock locks the hosts file against other instances until the returned func is called
+func (h *HostsFile) lock() (func(), error) {
+	if h.lockDir == "" {
+		return func() {}, nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(h.lockDir, "hosts.lock"), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error locking hosts file: %w", err)
+	}
+
+	deadline := time.Now().Add(hostsLockTimeout)
+	for {
+		err := lockFile(f)
+		if err == nil {
+			break
+		}
+		// errPortClaimed is returned while another instance holds the lock
+		if !errors.Is(err, errPortClaimed) || time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("error locking hosts file: %w", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return func() {
+		// closing releases the lock
+		_ = f.Close()
+	}, nil
+}
+
+// hostsBlock returns the lines mapping the names of all running forwards bound on an address of
+// the allocator, e.g. "127.1.0.1 api api.payments api.payments.svc.cluster.local". Short names
+// used by resources of several namespaces are left out.
+func hostsBlock(statuses []ForwardStatus, allocator *LoopbackAllocator) []byte {
+	names := make(map[string][]string)
+	owners := make(map[string]map[string]bool)
+	for _, s := range statuses {
+		if s.State == StateStopped || s.State == StateFailed || len(s.LocalAddresses) == 0 {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(s.LocalAddresses[0])
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || !allocator.Contains(ip) {
+			continue
+		}
+
+		if _, ok := names[host]; ok {
+			// another port of the same resource
+			continue
+		}
+		names[host] = hostNames(s.Resource)
+		for _, name := range names[host] {
+			if owners[name] == nil {
+				owners[name] = make(map[string]bool)
+			}
+			owners[name][host] = true
+		}
+	}
+
+	hosts := make([]string, 0, len(names))
+	for host := range names {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(hosts[i]).To4(), net.ParseIP(hosts[j]).To4()) < 0
+	})
+
+	var buf bytes.Buffer
+	for _, host := range hosts {
+		var unique []string
+		for _, name := range names[host] {
+			if len(owners[name]) == 1 {
+				unique = append(unique, name)
+			}
+		}
+		if len(unique) > 0 {
+			fmt.Fprintf(&buf, "%s\t%s\n", host, strings.Join(unique, " "))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// updateHostsBlock returns content with the block of pid replaced by block, the block is
+// removed if empty. Blocks of processes which aren't alive are removed as well.
+func updateHostsBlock(content []byte, pid int, block []byte, alive func(pid int) bool) []byte {
+	begin := "# BEGIN " + hostsMarker + " pid="
+	end := "# END " + hostsMarker + " pid="
+
+	var out bytes.Buffer
+	skipping := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if skipping {
+			if strings.HasPrefix(line, end) {
+				skipping = false
+			}
+			continue
+		}
+
+		if owner, ok := strings.CutPrefix(line, begin); ok {
+			p, err := strconv.Atoi(owner)
+			if err != nil || p == pid || !alive(p) {
+				skipping = true
+				continue
+			}
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	if len(block) > 0 {
+		fmt.Fprintf(&out, "%s%d\n", begin, pid)
+		out.Write(block)
+		fmt.Fprintf(&out, "%s%d\n", end, pid)
+	}
+
+	return out.Bytes()
+}
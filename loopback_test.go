@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateHostsBlock(t *testing.T) {
+	content := `127.0.0.1 localhost
+# BEGIN kubectl-multiforward pid=10
+127.1.0.1	stale
+# END kubectl-multiforward pid=10
+# BEGIN kubectl-multiforward pid=20
+127.1.0.2	other
+# END kubectl-multiforward pid=20
+# BEGIN kubectl-multiforward pid=30
+127.1.0.3	previous
+# END kubectl-multiforward pid=30
+::1 localhost`
+
+	alive := func(pid int) bool {
+		return pid == 20
+	}
+
+	got := string(updateHostsBlock([]byte(content), 30, []byte("127.1.0.3\tapi\n"), alive))
+	want := `127.0.0.1 localhost
+# BEGIN kubectl-multiforward pid=20
+127.1.0.2	other
+# END kubectl-multiforward pid=20
+::1 localhost
+# BEGIN kubectl-multiforward pid=30
+127.1.0.3	api
+# END kubectl-multiforward pid=30
+`
+	if got != want {
+		t.Errorf("updateHostsBlock() =\n%s\nwant\n%s", got, want)
+	}
+
+	got = string(updateHostsBlock([]byte(want), 30, nil, alive))
+	want = `127.0.0.1 localhost
+# BEGIN kubectl-multiforward pid=20
+127.1.0.2	other
+# END kubectl-multiforward pid=20
+::1 localhost
+`
+	if got != want {
+		t.Errorf("updateHostsBlock() without block =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHostsBlock(t *testing.T) {
+	allocator, err := NewLoopbackAllocator("127.1.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(resource Resource, address string, state ForwardState) ForwardStatus {
+		return ForwardStatus{Resource: resource, State: state, LocalAddresses: []string{address}}
+	}
+
+	statuses := []ForwardStatus{
+		status(Resource{Type: Service, Namespace: "payments", Name: "api", Ports: "0:80"}, "127.1.0.2:80", StateReady),
+		status(Resource{Type: Service, Namespace: "payments", Name: "api", Ports: "0:443"}, "127.1.0.2:443", StateReady),
+		status(Resource{Type: Service, Namespace: "orders", Name: "api", Ports: "0:80"}, "127.1.0.10:80", StateBackoff),
+		status(Resource{Type: Pod, Namespace: "orders", Name: "db-0", Ports: "0:5432"}, "127.1.0.3:5432", StateReady),
+		// not allocated, stopped
+		status(Resource{Type: Service, Namespace: "orders", Name: "web", Ports: "8080:80"}, "127.0.0.1:8080", StateReady),
+		status(Resource{Type: Service, Namespace: "orders", Name: "cache", Ports: "0:6379"}, "127.1.0.4:6379", StateStopped),
+	}
+
+	got := string(hostsBlock(statuses, allocator))
+	want := "127.1.0.2\tapi.payments api.payments.svc.cluster.local\n" +
+		"127.1.0.3\tdb-0 db-0.orders\n" +
+		"127.1.0.10\tapi.orders api.orders.svc.cluster.local\n"
+	if got != want {
+		t.Errorf("hostsBlock() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNewLoopbackAllocator(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/24", "::1/128", "invalid"} {
+		if _, err := NewLoopbackAllocator(cidr); err == nil {
+			t.Errorf("expected an error for %s", cidr)
+		}
+	}
+}
+
+// freeLoopbackPort returns a port which is free on the addresses of 127.1.2.0/24
+func freeLoopbackPort(t *testing.T) uint16 {
+	t.Helper()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("only the first loopback address is configured by default")
+	}
+
+	l, err := net.Listen("tcp", "127.1.2.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestLoopbackAllocator(t *testing.T) {
+	port := freeLoopbackPort(t)
+
+	allocator, err := NewLoopbackAllocator("127.1.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bound by someone else
+	taken, err := net.Listen("tcp", fmt.Sprintf("127.1.2.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = taken.Close()
+	}()
+
+	listen := func(resource Resource) string {
+		t.Helper()
+
+		listeners, err := allocator.Listen(resource, ForwardedPort{Remote: port})
+		if err != nil {
+			t.Fatalf("Listen() returned an error: %v", err)
+		}
+		t.Cleanup(func() {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+		})
+
+		return listeners[0].Addr().String()
+	}
+
+	foo := Resource{Type: Service, Namespace: "ns", Name: "foo"}
+	bar := Resource{Type: Service, Namespace: "ns", Name: "bar"}
+
+	if got, want := listen(foo), fmt.Sprintf("127.1.2.2:%d", port); got != want {
+		t.Errorf("foo is bound on %s, want %s", got, want)
+	}
+	if got, want := listen(bar), fmt.Sprintf("127.1.2.3:%d", port); got != want {
+		t.Errorf("bar is bound on %s, want %s", got, want)
+	}
+
+	// the address of a resource is kept
+	if _, err := allocator.Listen(foo, ForwardedPort{Remote: port}); err == nil {
+		t.Errorf("expected an error binding the same port of foo again")
+	}
+}
+
+func TestHostsFile(t *testing.T) {
+	port := freeLoopbackPort(t)
+
+	allocator, err := NewLoopbackAllocator("127.1.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := NewHostsFile(path, t.TempDir(), allocator, drainReports(t))
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t), hosts)
+	defer forwarder.Stop()
+	forwarder.SetListenFunc(allocator.Listen)
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: fmt.Sprintf("0:%d", port)}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+	if got, want := status.LocalAddresses[0], fmt.Sprintf("127.1.2.1:%d", port); got != want {
+		t.Errorf("forward is bound on %s, want %s", got, want)
+	}
+
+	conn, err := net.Dial("tcp", status.LocalAddresses[0])
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	echo(t, conn, "hello")
+	_ = conn.Close()
+
+	if err := hosts.Update(forwarder.Status()); err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "127.1.2.1\tfoo foo.ns\n") {
+		t.Errorf("expected foo to be mapped, got:\n%s", content)
+	}
+
+	if err := hosts.Remove(); err != nil {
+		t.Fatalf("Remove() returned an error: %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	if string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("expected the block to be removed, got:\n%s", content)
+	}
+
+	// e.g. by a state change during shutdown
+	if err := hosts.Update(forwarder.Status()); err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	if string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("expected the block to stay removed, got:\n%s", content)
+	}
+}
+
+func TestHostsFileLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files aren't locked")
+	}
+
+	allocator, err := NewLoopbackAllocator("127.1.3.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lockDir := t.TempDir()
+
+	// another instance updating the file
+	other := NewHostsFile(path, lockDir, allocator, drainReports(t))
+	unlock, err := other.lock()
+	if err != nil {
+		t.Fatalf("lock() returned an error: %v", err)
+	}
+
+	hosts := NewHostsFile(path, lockDir, allocator, drainReports(t))
+	done := make(chan error, 1)
+	go func() {
+		done <- hosts.write([]byte("127.1.3.1\tfoo\n"), false)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("write() returned while the file is locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("write() returned an error: %v", err)
+	}
+	if content, _ := os.ReadFile(path); !strings.Contains(string(content), "127.1.3.1\tfoo") {
+		t.Errorf("expected the block to be written, got:\n%s", content)
+	}
+}
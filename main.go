@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
@@ -21,35 +27,163 @@ var (
 	date    = "unknown"
 )
 
+// options holds the values of all command line flags
+type options struct {
+	namespace      string
+	kubeConfigPath string
+	severity       string
+	// componentSeverity overrides severity for single components
+	componentSeverity map[string]string
+	logFormat         string
+	color             string
+	timestamps        bool
+	configPath        string
+	drainTimeout      time.Duration
+	ui                bool
+	metricsAddr       string
+	auditFile         string
+	adminAddr         string
+	// hooks are run for all forwards, unless overridden in the config file
+	hooks Hooks
+	// waitReady signals once all forwards are ready, readyTimeout limits the time to wait for it
+	waitReady    bool
+	readyTimeout time.Duration
+	readyFile    string
+	// envFile and template are rendered whenever the local endpoints of forwards change
+	envFile     string
+	template    string
+	templateOut string
+	// controlSocket is the socket status and stop talk to the running instance over
+	controlSocket string
+	// detach runs the instance in the background, logging to logFile
+	detach  bool
+	pidFile string
+	logFile string
+	// loopbackIPs binds every resource on an address of its own from loopbackCIDR, named in hostsFile
+	loopbackIPs  bool
+	loopbackCIDR string
+	hostsFile    string
+	// dnsAddr serves the local addresses of forwards by DNS, other queries are forwarded to dnsUpstream
+	dnsAddr     string
+	dnsUpstream string
+	// socksAddr and httpProxyAddr serve proxies to resources of the cluster, connected on demand
+	socksAddr     string
+	httpProxyAddr string
+	// tls are the names of the forwards served as HTTPS with certificates of the CA in tlsCADir
+	tls      []string
+	tlsCADir string
+	// captureDir receives a pcapng file per forward with the streams of its connections
+	captureDir string
+	// gatewayAddr serves an HTTP reverse proxy to the forwards, routed by host or path
+	gatewayAddr string
+}
+
 func main() {
-	var namespace string
-	var kubeConfigPath string
-	var severity string
+	var opts options
 
 	var rootCmd = &cobra.Command{
-		Use:   "kubectl-multiforward [flags] resource1 resource2 ... resourceN",
+		Use:   "kubectl-multiforward [flags] resource1 resource2 ... resourceN [-- command args...]",
 		Short: "Port-Forward multiple k8s resources simultaneously",
 		Long: `
 Port-Forward multiple k8s resources simultaneously.
 
 A resource is specified as [namespace/]type/name:localPort:remotePort.
+Resources can be passed as arguments and/or in a config file (--config),
+the config file is reloaded when it changes or on SIGHUP.
 
 Following resource types can be forwarded:
  - pods
  - deployments
  - services
+
+While running, forwards can be changed by commands on stdin:
+ - add [namespace/]type/name:localPort:remotePort
+ - rm [namespace/]type/name[:localPort:remotePort]
+ - restart [namespace/]type/name[:localPort:remotePort]
+ - status
+
+The running instance can be inspected and stopped from any terminal
+by the status and stop commands.
+
+If a command is given after --, it's run once all forwards are ready.
+Afterward the forwards are stopped, the exit code is the one of the command.
+
+With --detach, the instance is run in the background and logs to a file.
+A second instance claiming the same local ports is refused.
 `,
 		Version: fmt.Sprintf("%s (commit: %s, date: %s)", version, commit, date),
-		Args:    cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			resources, command := splitCommand(cmd, args)
+			if len(resources) == 0 && opts.configPath == "" {
+				return fmt.Errorf("requires at least 1 resource or a config file")
+			}
+			if cmd.ArgsLenAtDash() >= 0 && len(command) == 0 {
+				return fmt.Errorf("requires a command after --")
+			}
+			if len(command) > 0 && opts.ui {
+				return fmt.Errorf("a command can't be run with --ui")
+			}
+			if opts.waitReady = cmd.Flags().Changed("wait-ready"); opts.waitReady && opts.ui {
+				return fmt.Errorf("--wait-ready can't be used with --ui")
+			}
+			if opts.templateOut != "" && opts.template == "" {
+				return fmt.Errorf("--template-out requires --template")
+			}
+			if opts.template != "" && opts.templateOut == "" && opts.ui {
+				return fmt.Errorf("--template can't be rendered to stdout with --ui")
+			}
+			if opts.detach && (opts.ui || len(command) > 0) {
+				return fmt.Errorf("--detach can't be used with --ui or a command")
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			forward(args, namespace, kubeConfigPath, severity)
+			resources, command := splitCommand(cmd, args)
+			run(resources, command, opts)
 		},
 	}
 
+	rootCmd.PersistentFlags().StringVar(&opts.controlSocket, "control-socket", controlSocketPath(), "unix socket the running instance is controlled by the status and stop commands over")
+	rootCmd.AddCommand(newStatusCommand(&opts.controlSocket), newStopCommand(&opts.controlSocket))
+
 	flags := rootCmd.Flags()
-	flags.StringVarP(&namespace, "namespace", "n", "", "k8s namespace which will be used for all resources (if not set otherwise)")
-	flags.StringVarP(&kubeConfigPath, "kubeconfig", "k", filepath.Join(homedir.HomeDir(), ".kube", "config"), "path to kubeconfig file")
-	flags.StringVarP(&severity, "severity", "s", "info", "log severity (trace, debug, info, warning, error)")
+	flags.StringVarP(&opts.namespace, "namespace", "n", "", "k8s namespace which will be used for all resources (if not set otherwise)")
+	flags.StringVarP(&opts.kubeConfigPath, "kubeconfig", "k", filepath.Join(homedir.HomeDir(), ".kube", "config"), "path to kubeconfig file")
+	flags.StringVarP(&opts.severity, "severity", "s", "info", "log severity (trace, debug, info, warning, error)")
+	flags.StringToStringVar(&opts.componentSeverity, "component-severity", nil, "log severity per component (main, forwarder, config, command, metrics, admin, hooks, template, systemd, hosts, dns, proxy, tls, capture), e.g. forwarder=debug")
+	flags.StringVar(&opts.logFormat, "log-format", "text", "log format (text, json)")
+	flags.StringVar(&opts.color, "color", "auto", "colorize text logs (auto, always, never), auto respects NO_COLOR")
+	flags.BoolVar(&opts.timestamps, "timestamps", false, "prefix text logs with timestamps")
+	flags.StringVarP(&opts.configPath, "config", "c", "", "path to a config file with forwards, reloaded on changes")
+	flags.BoolVar(&opts.ui, "ui", false, "show a full-screen dashboard instead of logs")
+	flags.StringVar(&opts.metricsAddr, "metrics-addr", "", "address to serve prometheus metrics on, e.g. localhost:9090")
+	flags.StringVar(&opts.auditFile, "audit-file", "", "append a JSON line per finished connection to this file")
+	flags.StringVar(&opts.adminAddr, "admin-addr", "", "address to serve the admin API on, e.g. localhost:9091 or unix:/tmp/multiforward.sock")
+	flags.StringVar(&opts.hooks.OnReady, "on-ready", "", "shell command to run when a forward is ready for the first time")
+	flags.StringVar(&opts.hooks.OnReconnect, "on-reconnect", "", "shell command to run when a forward is ready again")
+	flags.StringVar(&opts.hooks.OnPodChange, "on-pod-change", "", "shell command to run when a forward moved to another pod")
+	flags.StringVar(&opts.hooks.OnFail, "on-fail", "", "shell command to run when a forward failed, once until it's ready again")
+	flags.DurationVar(&opts.readyTimeout, "wait-ready", 0, "print a line (or JSON document with --log-format=json) once all forwards are ready, to stderr if a command is run, exit if they aren't ready after the optional timeout")
+	flags.Lookup("wait-ready").NoOptDefVal = "0s"
+	flags.StringVar(&opts.readyFile, "ready-file", "", "write the status of all forwards to this file once they are ready")
+	flags.StringVar(&opts.envFile, "env-file", "", "write the local endpoints of all ready forwards to this env file, e.g. PAYMENTS_API_HOST/PORT")
+	flags.StringVar(&opts.template, "template", "", "render the local endpoints of all ready forwards through this text/template")
+	flags.StringVar(&opts.templateOut, "template-out", "", "file the template is rendered to (default stdout)")
+	flags.BoolVar(&opts.detach, "detach", false, "run in the background, logging to --log-file")
+	flags.StringVar(&opts.pidFile, "pid-file", "", "write the pid to this file (default kubectl-multiforward.pid in the user cache dir with --detach)")
+	flags.StringVar(&opts.logFile, "log-file", "", "write logs to this file instead of stdout, rotated at 10MB (default kubectl-multiforward.log in the user cache dir with --detach)")
+	flags.BoolVar(&opts.loopbackIPs, "loopback-ips", false, "bind the remote port of every resource on a loopback address of its own, instead of the local port on localhost")
+	flags.StringVar(&opts.loopbackCIDR, "loopback-cidr", "127.1.0.0/24", "network the addresses of --loopback-ips are allocated from")
+	flags.StringVar(&opts.hostsFile, "hosts-file", defaultHostsFile(), "hosts file the names of resources are mapped to their addresses in with --loopback-ips, empty to disable")
+	flags.StringVar(&opts.dnsAddr, "dns-addr", "", "address to serve DNS for the names of forwarded resources on, e.g. 127.0.0.53:5353")
+	flags.StringVar(&opts.dnsUpstream, "dns-upstream", "", "DNS server other queries are forwarded to, e.g. 1.1.1.1:53 (default answer NXDOMAIN)")
+	flags.StringVar(&opts.socksAddr, "socks5", "", "address to serve a SOCKS5 proxy to services and pods of the cluster on, e.g. 127.0.0.1:1080")
+	flags.StringSliceVar(&opts.tls, "tls", nil, "serve the local ports of these resources as HTTPS, e.g. payments/service/web, service/web or web")
+	flags.StringVar(&opts.tlsCADir, "tls-ca-dir", "", "directory of the CA the certificates of --tls are issued by, created if missing (default kubectl-multiforward in the user config dir)")
+	flags.StringVar(&opts.captureDir, "capture-dir", "", "write the connections of every forward to a pcapng file in this directory, to be decoded by Wireshark")
+	flags.StringVar(&opts.httpProxyAddr, "http-proxy", "", "address to serve an HTTP CONNECT proxy to services and pods of the cluster on, e.g. 127.0.0.1:3128")
+	flags.StringVar(&opts.gatewayAddr, "gateway-addr", "", "address to serve an HTTP reverse proxy to the forwards on, routed by host (web.payments.localhost) or path (/web.payments/), e.g. 127.0.0.1:8000")
+	flags.DurationVar(&opts.drainTimeout, "drain-timeout", 10*time.Second, "time to wait for active connections to finish on shutdown")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error executing root command: %s\n", err.Error())
@@ -57,32 +191,48 @@ Following resource types can be forwarded:
 	}
 }
 
-func forward(resources []string, namespace string, kubeConfigPath string, severity string) {
-	if len(resources) == 0 {
+// splitCommand splits the arguments into resources and the command after --, if any
+func splitCommand(cmd *cobra.Command, args []string) (resources []string, command []string) {
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		return args[:dash], args[dash:]
+	}
+
+	return args, nil
+}
+
+func run(resources []string, command []string, opts options) {
+	if len(resources) == 0 && opts.configPath == "" {
 		// cannot happen
 		panic("no resources specified")
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	namespace := opts.namespace
+	config, err := clientcmd.BuildConfigFromFlags("", opts.kubeConfigPath)
 	if err != nil {
 		log.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
 	if strings.TrimSpace(namespace) == "" {
-		namespace, err = getDefaultNamespaceFromCtx(kubeConfigPath)
+		namespace, err = getDefaultNamespaceFromCtx(opts.kubeConfigPath)
 		if err != nil {
 			fmt.Printf("couldn't determine default namespace, using 'default': %s\n", err.Error())
 			namespace = "default"
 		}
 	}
 
-	currentSeverity, err = SeverityFromString(severity)
+	// stdout belongs to the command, if there is one
+	logOut, logErr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if len(command) > 0 {
+		logOut = os.Stderr
+	}
+
+	logger, err := newLogger(opts, logOut, logErr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error recognizing severity: %s\n", err.Error())
+		fmt.Fprintf(os.Stderr, "Error configuring logging: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	var poder []Poder
+	var resourceList []Resource
 	for _, s := range resources {
 		r, err := ParseResource(s)
 		if err != nil {
@@ -92,34 +242,545 @@ func forward(resources []string, namespace string, kubeConfigPath string, severi
 		if r.Namespace == "" {
 			r.Namespace = namespace
 		}
-		poder = append(poder, NewPoder(config, r))
+		resourceList = append(resourceList, r)
+	}
+
+	// the state dir is required by --detach, otherwise local ports are claimed only if it's available
+	dir, err := stateDir()
+	if err != nil {
+		if opts.detach {
+			fmt.Fprintf(os.Stderr, "Error creating state dir: %s\n", err.Error())
+			os.Exit(1)
+		}
+		NewReport(SeverityWarning, nil, "local ports aren't claimed, error creating state dir").WithErr(err).Log(logger)
+	}
+	if opts.detach && opts.pidFile == "" {
+		opts.pidFile = filepath.Join(dir, "kubectl-multiforward.pid")
+	}
+	if opts.detach && opts.logFile == "" {
+		opts.logFile = filepath.Join(dir, "kubectl-multiforward.log")
 	}
 
-	forwarder := NewForwarder(config)
+	// a second instance claiming the same local ports is refused, loopback addresses are
+	// allocated from the ones not bound yet instead. Only the ports given on startup are
+	// claimed, not the ones of forwards added later on, e.g. by stdin or a config reload.
+	var claimed []uint16
+	if !opts.loopbackIPs {
+		claimed = append(localPorts(resourceList), localPorts(configResources(opts.configPath, namespace))...)
+	}
+
+	if opts.detach && !isDetached() {
+		// fail early, the background instance can only log its errors
+		if opts.pidFile != "" {
+			if err := checkPidFile(opts.pidFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error detaching: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}
+		lock, err := lockPorts(dir, claimed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error detaching: %s\n", err.Error())
+			os.Exit(1)
+		}
+		lock.Unlock()
+
+		pid, err := detach(os.Args[1:], opts.logFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error detaching: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("running in the background with pid %d, logging to %s\n", pid, opts.logFile)
+		os.Exit(0)
+	}
+
+	lock := &portLock{}
+	if dir != "" {
+		l, err := lockPorts(dir, claimed)
+		switch {
+		case err == nil:
+			lock = l
+		case opts.detach || errors.Is(err, errPortClaimed):
+			fmt.Fprintf(os.Stderr, "Error claiming local ports: %s\n", err.Error())
+			os.Exit(1)
+		default:
+			NewReport(SeverityWarning, nil, "local ports aren't claimed").WithErr(err).Log(logger)
+		}
+	}
+
+	if opts.pidFile != "" {
+		if err := writePidFile(opts.pidFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing pid file: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var logFile *rotatingFile
+	if opts.logFile != "" {
+		logFile, err = openRotatingFile(opts.logFile, logFileMaxSize, logFileBackups)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening log file: %s\n", err.Error())
+			os.Exit(1)
+		}
+		// lines of a log file are useless without timestamps
+		fileOpts := opts
+		fileOpts.timestamps = true
+		logger, _ = newLogger(fileOpts, logFile, logFile)
+	}
 
 	stopChan := make(chan struct{})
-	reportChan := make(chan Report, len(poder)*10)
-	doneChan, err := forwarder.Forward(poder, stopChan, reportChan)
+	reportChan := make(chan Report, 100)
+	// reports are logged by the main loop, which starts only once all forwards are set up
+	reports := queueReports(reportChan, reportQueueSize)
+
+	metrics := NewMetrics()
+	hooks := NewHookRunner(opts.hooks, reportChan)
+	renderer := NewRenderer(reportChan)
+	if opts.envFile != "" {
+		renderer.AddEnvFile(opts.envFile)
+	}
+	if opts.template != "" {
+		if err := renderer.AddTemplate(opts.template, opts.templateOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading template: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	observers := []Observer{metrics, hooks, renderer}
+
+	listen := ListenLoopback
+	var hostsFile *HostsFile
+	if opts.loopbackIPs {
+		allocator, err := NewLoopbackAllocator(opts.loopbackCIDR)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing loopback network: %s\n", err.Error())
+			os.Exit(1)
+		}
+		listen = allocator.Listen
+
+		if opts.hostsFile != "" {
+			// the lock against other instances is skipped without state dir
+			hostsFile = NewHostsFile(opts.hostsFile, dir, allocator, reportChan)
+			// removes blocks left by crashed instances
+			if err := hostsFile.Cleanup(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating hosts file: %s\n", err.Error())
+				os.Exit(1)
+			}
+			observers = append(observers, hostsFile)
+		}
+	}
+
+	// notifies systemd if run as service of Type=notify
+	systemd := NewSystemd(reportChan)
+	if systemd != nil {
+		observers = append(observers, systemd)
+	}
+
+	var audit *AuditLog
+	if opts.auditFile != "" {
+		audit, err = OpenAuditLog(opts.auditFile, reportChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening audit file: %s\n", err.Error())
+			os.Exit(1)
+		}
+		observers = append(observers, audit)
+	}
+
+	var capture *Capture
+	if opts.captureDir != "" {
+		if err := os.MkdirAll(opts.captureDir, 0o700); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating capture dir: %s\n", err.Error())
+			os.Exit(1)
+		}
+		capture = NewCapture(opts.captureDir, config, reportChan)
+		observers = append(observers, capture)
+	}
+
+	forwarder := NewForwarder(config, reportChan, observers...)
+	if capture != nil {
+		forwarder.SetCapture(capture)
+	}
+
+	// listeners passed by systemd socket activation are used for the forwards they are named after
+	activation, err := NewSocketActivation(listen)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error using socket activation: %s\n", err.Error())
+		os.Exit(1)
+	}
+	// local ports of selected forwards are served as HTTPS, also if selected by the config file later on
+	tlsTerminator := NewTLSTerminator(opts.tlsCADir, opts.tls, activation.Listen, reportChan)
+	forwarder.SetListenFunc(tlsTerminator.Listen)
+	if names := activation.Names(); len(names) > 0 {
+		reportChan <- NewReport(SeverityInfo, nil, "using socket-activated listeners %s", strings.Join(names, ", ")).WithComponent(ComponentSystemd)
+	}
+
+	if opts.metricsAddr != "" {
+		if err := serveMetrics(opts.metricsAddr, metrics, forwarder, reportChan); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving metrics: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	go renderer.Run(forwarder, stopChan)
+	if hostsFile != nil {
+		go hostsFile.Run(forwarder, stopChan)
+	}
+
+	if err := forwarder.Forward(resourceList); err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting forwarder: %s\n", err.Error())
 		os.Exit(1)
 	}
 
+	shutdownChan := make(chan struct{}, 1)
+
+	var admin net.Listener
+	if opts.adminAddr != "" {
+		admin, err = serveAdmin(opts.adminAddr, forwarder, namespace, reportChan, shutdownChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving admin API: %s\n", err.Error())
+			os.Exit(1)
+		}
+		reportChan <- NewReport(SeverityInfo, nil, "serving admin API on %s", opts.adminAddr).WithComponent(ComponentAdmin)
+	}
+
+	var dns *DNSServer
+	if opts.dnsAddr != "" {
+		dns, err = serveDNS(opts.dnsAddr, opts.dnsUpstream, forwarder, namespace, reportChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving DNS: %s\n", err.Error())
+			os.Exit(1)
+		}
+		reportChan <- NewReport(SeverityInfo, nil, "serving DNS on %s", dns.Addr()).WithComponent(ComponentDNS)
+	}
+
+	var gateway net.Listener
+	if opts.gatewayAddr != "" {
+		gateway, err = serveGateway(opts.gatewayAddr, forwarder, namespace, reportChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving gateway: %s\n", err.Error())
+			os.Exit(1)
+		}
+		reportChan <- NewReport(SeverityInfo, nil, "serving HTTP gateway on %s", gateway.Addr()).WithComponent(ComponentProxy)
+	}
+
+	// the proxies share tunnels to pods
+	var pool *TunnelPool
+	if opts.socksAddr != "" || opts.httpProxyAddr != "" {
+		pool = NewTunnelPool(config, namespace)
+	}
+
+	var socks net.Listener
+	if opts.socksAddr != "" {
+		socks, err = serveSOCKS(opts.socksAddr, pool, reportChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving SOCKS5 proxy: %s\n", err.Error())
+			os.Exit(1)
+		}
+		reportChan <- NewReport(SeverityInfo, nil, "serving SOCKS5 proxy on %s", socks.Addr()).WithComponent(ComponentProxy)
+	}
+
+	var httpProxy net.Listener
+	if opts.httpProxyAddr != "" {
+		httpProxy, err = serveHTTPProxy(opts.httpProxyAddr, pool, reportChan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving HTTP proxy: %s\n", err.Error())
+			os.Exit(1)
+		}
+		reportChan <- NewReport(SeverityInfo, nil, "serving HTTP proxy on %s", httpProxy.Addr()).WithComponent(ComponentProxy)
+	}
+
+	// the status and stop commands find this instance by its control socket
+	control, err := serveControl(opts.controlSocket, forwarder, namespace, reportChan, shutdownChan)
+	if err != nil {
+		reportChan <- NewReport(SeverityWarning, nil, "status and stop commands are not available for this instance").WithComponent(ComponentAdmin).WithErr(err)
+	}
+
+	if opts.configPath != "" {
+		reloader := newConfigReloader(opts.configPath, namespace, forwarder, hooks, tlsTerminator, reportChan)
+		if err := reloader.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go reloader.Watch(hup, stopChan)
+	}
+
+	var ui *dashboard
+	var keys <-chan uiKey
+	var refresh <-chan time.Time
+	if opts.ui {
+		ui, err = newDashboard(forwarder, reportChan, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting dashboard: %s\n", err.Error())
+			os.Exit(1)
+		}
+		// the terminal is restored also if the main loop panics, e.g. while rendering
+		defer func() {
+			if r := recover(); r != nil {
+				ui.Close()
+				panic(r)
+			}
+		}()
+		// reports are shown in the log pane of the dashboard
+		logger, _ = newLogger(opts, ui.logs, ui.logs)
+		keys = ui.Keys()
+
+		t := time.NewTicker(uiRefreshInterval)
+		defer t.Stop()
+		refresh = t.C
+		ui.Render()
+	} else if len(command) == 0 && !isDetached() {
+		// forwards can be added, removed and restarted by commands on stdin
+		go readCommands(os.Stdin, os.Stdout, forwarder, namespace, reportChan)
+	}
+
+	// the command is run until it exits, then all forwards are stopped
+	var execCmd *execCommand
+	execDone := make(chan int, 1)
+	exitCode := 0
+	waitCtx, cancelWait := context.WithCancel(context.Background())
+	defer cancelWait()
+	if len(command) > 0 {
+		// unless the command exits, e.g. because the forwards don't become ready
+		exitCode = 1
+		execCmd = newExecCommand(command)
+		go func() {
+			execDone <- execCmd.Run(waitCtx, forwarder, reportChan)
+		}()
+	}
+
+	var watchdog <-chan time.Time
+	if systemd != nil {
+		go systemd.Run(waitCtx, forwarder)
+
+		if interval := systemd.WatchdogInterval(); interval > 0 {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			watchdog = t.C
+		}
+	}
+
+	// the forwards are ready once the ready gate is passed
+	readyFailed := make(chan struct{})
+	gate := readyGate{forwarder: forwarder, file: opts.readyFile}
+	if opts.waitReady || opts.readyFile != "" {
+		if opts.waitReady {
+			gate.out = os.Stdout
+			if len(command) > 0 {
+				// stdout belongs to the command
+				gate.out = os.Stderr
+			}
+			gate.format, _ = LogFormatFromString(opts.logFormat)
+		}
+
+		go func() {
+			ctx := waitCtx
+			if opts.readyTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.readyTimeout)
+				defer cancel()
+			}
+
+			if err := gate.Wait(ctx); err != nil {
+				reportChan <- NewReport(SeverityError, nil, "forwards didn't become ready").WithErr(err)
+				close(readyFailed)
+			}
+		}()
+	}
+
+	exit := func(code int) {
+		if ui != nil {
+			ui.Close()
+		}
+		if audit != nil {
+			_ = audit.Close()
+		}
+		if admin != nil {
+			_ = admin.Close()
+		}
+		if control != nil {
+			_ = control.Close()
+		}
+		if dns != nil {
+			_ = dns.Close()
+		}
+		if gateway != nil {
+			_ = gateway.Close()
+		}
+		if socks != nil {
+			_ = socks.Close()
+		}
+		if httpProxy != nil {
+			_ = httpProxy.Close()
+		}
+		if capture != nil {
+			capture.Close()
+		}
+		if pool != nil {
+			pool.Close()
+		}
+		gate.Remove()
+		if hostsFile != nil {
+			if err := hostsFile.Remove(); err != nil {
+				NewReport(SeverityWarning, nil, "error cleaning up hosts file").WithComponent(ComponentHosts).WithErr(err).Log(logger)
+			}
+		}
+		if opts.pidFile != "" {
+			removePidFile(opts.pidFile)
+		}
+		lock.Unlock()
+		if logFile != nil {
+			_ = logFile.Close()
+		}
+		os.Exit(code)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	doneChan := make(chan struct{})
+	shutdown := func() {
+		select {
+		case <-stopChan:
+			NewReport(SeverityWarning, nil, "received second stop signal, forcing exit").Log(logger)
+			exit(1)
+		default:
+		}
+
+		NewReport(SeverityInfo, nil, "stopping all forwarders, waiting up to %s for active connections (repeat to force)...", opts.drainTimeout).Log(logger)
+		close(stopChan)
+		cancelWait()
+		if systemd != nil {
+			_ = systemd.Notify("STOPPING=1")
+		}
+		go func() {
+			defer close(doneChan)
+
+			ctx, cancel := context.WithTimeout(context.Background(), opts.drainTimeout)
+			defer cancel()
+			forwarder.Shutdown(ctx)
+
+			// hooks run by the last state changes are killed once they time out
+			hooksCtx, cancelHooks := context.WithTimeout(context.Background(), hookTimeout+time.Second)
+			defer cancelHooks()
+			if err := hooks.Wait(hooksCtx); err != nil {
+				reportChan <- NewReport(SeverityWarning, nil, "hooks didn't finish").WithComponent(ComponentHooks).WithErr(err)
+			}
+		}()
+	}
+
+	signaled := false
 	for {
 		select {
-		case <-c:
-			NewReport(SeverityInfo, nil, "sending stop signal to all forwarders...").Dump()
-			close(stopChan)
-		case report := <-reportChan:
-			report.Dump()
+		case sig := <-c:
+			// the command decides how to handle the first signal, its exit stops the forwards
+			if execCmd != nil && !signaled && execCmd.Signal(sig) {
+				signaled = true
+				continue
+			}
+			shutdown()
+		case <-readyFailed:
+			readyFailed = nil
+			exitCode = 1
+			select {
+			case <-stopChan:
+			default:
+				shutdown()
+			}
+		case exitCode = <-execDone:
+			select {
+			case <-stopChan:
+				// already stopping, e.g. the forwards didn't become ready
+			default:
+				shutdown()
+			}
+		case <-shutdownChan:
+			shutdown()
+		case k := <-keys:
+			if !ui.HandleKey(k) {
+				shutdown()
+			}
+			ui.Render()
+		case <-refresh:
+			ui.Render()
+		case <-watchdog:
+			// pings only while this loop and the forwards are responsive
+			if err := systemd.Watchdog(forwarder); err != nil {
+				NewReport(SeverityWarning, nil, "error pinging systemd watchdog").WithComponent(ComponentSystemd).WithErr(err).Log(logger)
+			}
+		case report := <-reports:
+			report.Log(logger)
 		case <-doneChan:
-			NewReport(SeverityInfo, nil, "all forwarders finished, quit...").Dump()
-			os.Exit(0)
+			NewReport(SeverityInfo, nil, "all forwarders finished, quit...").Log(logger)
+			exit(exitCode)
+		}
+	}
+}
+
+// newLogger creates the logger reports are passed to, as configured by the flags,
+// errors are written to errOut. Output is colored only if written to a file.
+func newLogger(opts options, out, errOut io.Writer) (*slog.Logger, error) {
+	severity, err := SeverityFromString(opts.severity)
+	if err != nil {
+		return nil, err
+	}
+
+	levels, err := ParseComponentLevels(opts.componentSeverity)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := LogFormatFromString(opts.logFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	color, err := ColorModeFromString(opts.color)
+	if err != nil {
+		return nil, err
+	}
+
+	// filtering is done by the component level handler
+	var handler slog.Handler
+	switch format {
+	case LogFormatJSON:
+		handler = NewJSONHandler(out, LevelTrace)
+	default:
+		colored := func(w io.Writer) bool {
+			f, ok := w.(*os.File)
+			return ok && color.Enabled(f)
 		}
+
+		handler = NewConsoleHandler(out, errOut, &ConsoleHandlerOptions{
+			Level:      LevelTrace,
+			OutColor:   colored(out),
+			ErrColor:   colored(errOut),
+			Timestamps: opts.timestamps,
+		})
 	}
+
+	return slog.New(NewComponentLevelHandler(handler, severity.Level(), levels)), nil
+}
+
+// configResources returns the resources of the config file at path, none if there is
+// no or an invalid config file. Errors are reported once the config file is loaded.
+func configResources(path, namespace string) []Resource {
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	config, err := ParseConfig(content)
+	if err != nil {
+		return nil
+	}
+
+	resources, _ := config.Resources(namespace)
+	return resources
 }
 
 func getDefaultNamespaceFromCtx(kubeConfigPath string) (string, error) {
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPath is the path metrics are served on
+const metricsPath = "/metrics"
+
+// podResolutionBuckets are the upper bounds of the pod resolution latency histogram in seconds
+var podResolutionBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// allStates are all states a forward can be in, exposed as one series each
+var allStates = []ForwardState{StateResolving, StateConnecting, StateReady, StateBackoff, StateFailed, StateStopped}
+
+// histogram is a cumulative histogram as defined by prometheus
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Metrics collects metrics about the lifecycle of forwards which aren't part of their status,
+// it's an Observer of the Forwarder.
+type Metrics struct {
+	mu sync.Mutex
+	// backoff is the time spent in finished backoff periods by forward ID
+	backoff map[string]time.Duration
+	// backoffSince is the start of the current backoff period by forward ID
+	backoffSince map[string]time.Time
+	resolution   map[string]*histogram
+}
+
+var _ Observer = &Metrics{}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		backoff:      make(map[string]time.Duration),
+		backoffSince: make(map[string]time.Time),
+		resolution:   make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) StateChanged(status ForwardStatus, previous ForwardState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if since, ok := m.backoffSince[status.ID]; ok && previous == StateBackoff {
+		m.backoff[status.ID] += status.Since.Sub(since)
+		delete(m.backoffSince, status.ID)
+	}
+
+	switch status.State {
+	case StateBackoff:
+		m.backoffSince[status.ID] = status.Since
+	case StateStopped:
+		// the forward is gone, a forward added with the same ID starts from scratch
+		delete(m.backoff, status.ID)
+		delete(m.backoffSince, status.ID)
+		delete(m.resolution, status.ID)
+	}
+}
+
+func (m *Metrics) PodResolved(status ForwardStatus, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.resolution[status.ID]
+	if !ok {
+		h = newHistogram(podResolutionBuckets)
+		m.resolution[status.ID] = h
+	}
+	h.observe(took.Seconds())
+}
+
+func (m *Metrics) ConnectionClosed(ForwardStatus, ConnectionStats) {
+	// connections are counted by the forward itself
+}
+
+// Handler returns a handler serving the metrics of the forwards returned by status
+// in the prometheus text format.
+func (m *Metrics) Handler(status func() []ForwardStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.Write(w, status(), time.Now())
+	})
+}
+
+// Write writes the metrics of the given forwards in the prometheus text format.
+func (m *Metrics) Write(w io.Writer, statuses []ForwardStatus, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	family := func(name, typ, help string, each func(s ForwardStatus, labels string)) {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range statuses {
+			each(s, metricLabels(s))
+		}
+	}
+	sample := func(name, labels string, value float64) {
+		_, _ = fmt.Fprintf(bw, "%s{%s} %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	family("multiforward_received_bytes_total", "counter", "Bytes received from the pod.", func(s ForwardStatus, labels string) {
+		sample("multiforward_received_bytes_total", labels, float64(s.BytesIn))
+	})
+	family("multiforward_sent_bytes_total", "counter", "Bytes sent to the pod.", func(s ForwardStatus, labels string) {
+		sample("multiforward_sent_bytes_total", labels, float64(s.BytesOut))
+	})
+	family("multiforward_active_connections", "gauge", "Local connections currently forwarded.", func(s ForwardStatus, labels string) {
+		sample("multiforward_active_connections", labels, float64(s.ActiveConnections))
+	})
+	family("multiforward_connections_total", "counter", "Local connections accepted.", func(s ForwardStatus, labels string) {
+		sample("multiforward_connections_total", labels, float64(s.Connections))
+	})
+	family("multiforward_reconnects_total", "counter", "Times the forward became ready again.", func(s ForwardStatus, labels string) {
+		sample("multiforward_reconnects_total", labels, float64(s.Reconnects))
+	})
+	family("multiforward_backoff_seconds_total", "counter", "Time spent waiting to restart the forward after failures.", func(s ForwardStatus, labels string) {
+		backoff := m.backoff[s.ID]
+		if since, ok := m.backoffSince[s.ID]; ok {
+			backoff += now.Sub(since)
+		}
+		sample("multiforward_backoff_seconds_total", labels, backoff.Seconds())
+	})
+	family("multiforward_pod_resolution_seconds", "histogram", "Time it took to pick a pod for the forward.", func(s ForwardStatus, labels string) {
+		h, ok := m.resolution[s.ID]
+		if !ok {
+			h = newHistogram(podResolutionBuckets)
+		}
+		for i, upper := range h.buckets {
+			sample("multiforward_pod_resolution_seconds_bucket", labels+`,le="`+strconv.FormatFloat(upper, 'g', -1, 64)+`"`, float64(h.counts[i]))
+		}
+		sample("multiforward_pod_resolution_seconds_bucket", labels+`,le="+Inf"`, float64(h.count))
+		sample("multiforward_pod_resolution_seconds_sum", labels, h.sum)
+		sample("multiforward_pod_resolution_seconds_count", labels, float64(h.count))
+	})
+	family("multiforward_state", "gauge", "Current state of the forward, 1 for the current state and 0 otherwise.", func(s ForwardStatus, labels string) {
+		for _, state := range allStates {
+			value := 0.0
+			if s.State == state {
+				value = 1
+			}
+			sample("multiforward_state", labels+`,state="`+state.String()+`"`, value)
+		}
+	})
+
+	return bw.Flush()
+}
+
+// serveMetrics serves the metrics of all forwards on addr in the background.
+func serveMetrics(addr string, metrics *Metrics, forwarder *Forwarder, reportChan chan<- Report) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET "+metricsPath, metrics.Handler(forwarder.Status))
+
+	serveHTTP(l, mux, ComponentMetrics, reportChan)
+	reportChan <- NewReport(SeverityInfo, nil, "serving metrics on http://%s%s", l.Addr(), metricsPath).WithComponent(ComponentMetrics)
+
+	return nil
+}
+
+// metricLabels returns the labels identifying the forward
+func metricLabels(s ForwardStatus) string {
+	return fmt.Sprintf(`forward="%s",namespace="%s",resource="%s"`,
+		escapeLabel(s.ID), escapeLabel(s.Resource.Namespace), escapeLabel(s.Resource.Key()))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
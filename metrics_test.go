@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	status := ForwardStatus{
+		ID:                "ns/service/foo:8080:80",
+		Resource:          Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+		State:             StateBackoff,
+		Since:             now.Add(-10 * time.Second),
+		BytesIn:           100,
+		BytesOut:          20,
+		ActiveConnections: 1,
+		Connections:       3,
+		Reconnects:        2,
+	}
+
+	m := NewMetrics()
+	m.PodResolved(status, 20*time.Millisecond)
+	m.PodResolved(status, 2*time.Second)
+	m.StateChanged(status, StateReady)
+
+	// a finished backoff period of 10s
+	m.StateChanged(ForwardStatus{ID: status.ID, State: StateResolving, Since: now}, StateBackoff)
+	// and the current one
+	m.StateChanged(ForwardStatus{ID: status.ID, State: StateBackoff, Since: now.Add(-5 * time.Second)}, StateResolving)
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf, []ForwardStatus{status}, now); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+
+	labels := `forward="ns/service/foo:8080:80",namespace="ns",resource="ns/service/foo"`
+	for _, want := range []string{
+		"# TYPE multiforward_received_bytes_total counter",
+		"multiforward_received_bytes_total{" + labels + "} 100",
+		"multiforward_sent_bytes_total{" + labels + "} 20",
+		"multiforward_active_connections{" + labels + "} 1",
+		"multiforward_connections_total{" + labels + "} 3",
+		"multiforward_reconnects_total{" + labels + "} 2",
+		"multiforward_backoff_seconds_total{" + labels + "} 15",
+		"# TYPE multiforward_pod_resolution_seconds histogram",
+		"multiforward_pod_resolution_seconds_bucket{" + labels + `,le="0.01"} 0`,
+		"multiforward_pod_resolution_seconds_bucket{" + labels + `,le="0.025"} 1`,
+		"multiforward_pod_resolution_seconds_bucket{" + labels + `,le="2.5"} 2`,
+		"multiforward_pod_resolution_seconds_bucket{" + labels + `,le="+Inf"} 2`,
+		"multiforward_pod_resolution_seconds_sum{" + labels + "} 2.02",
+		"multiforward_pod_resolution_seconds_count{" + labels + "} 2",
+		"multiforward_state{" + labels + `,state="Backoff"} 1`,
+		"multiforward_state{" + labels + `,state="Ready"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	// metrics of stopped forwards are dropped
+	m.StateChanged(ForwardStatus{ID: status.ID, State: StateStopped, Since: now}, StateBackoff)
+	buf.Reset()
+	_ = m.Write(&buf, []ForwardStatus{status}, now)
+	if want := "multiforward_backoff_seconds_total{" + labels + "} 0\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("metrics don't contain %q after the forward was stopped:\n%s", want, buf.String())
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got, want := escapeLabel("a\"b\\c\nd"), `a\"b\\c\nd`; got != want {
+		t.Errorf("escapeLabel() = %s, want %s", got, want)
+	}
+}
@@ -6,15 +6,21 @@ import (
 	"math/rand"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 type Poder interface {
+	// String returns the key of the resource, e.g. ns/service/foo, as Resource.Key does
 	fmt.Stringer
+	// Pod returns a random pod of the resource
 	Pod() (string, error)
+	// Pods returns all pods of the resource
+	Pods() ([]string, error)
 	Namespace() string
 	Ports() []string
 }
@@ -45,12 +51,21 @@ func (p podPoder) Pod() (string, error) {
 	return p.pod, nil
 }
 
+func (p podPoder) Pods() ([]string, error) {
+	pod, err := p.Pod()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{pod}, nil
+}
+
 func (p podPoder) Ports() []string {
 	return p.ports
 }
 
 func (p podPoder) String() string {
-	return fmt.Sprintf("%s/%s", p.namespace, p.pod)
+	return fmt.Sprintf("%s/%s/%s", p.namespace, Pod, p.pod)
 }
 
 func NewPoder(config *rest.Config, resource Resource) Poder {
@@ -103,8 +118,12 @@ func (p servicePoder) Pod() (string, error) {
 	return PickRandomPod(p.k8sConfig, p.namespace, p.service, fetchPodsForService)
 }
 
+func (p servicePoder) Pods() ([]string, error) {
+	return fetchPodsForService(p.k8sConfig, p.namespace, p.service)
+}
+
 func (p servicePoder) String() string {
-	return fmt.Sprintf("%s/%s", p.namespace, p.service)
+	return fmt.Sprintf("%s/%s/%s", p.namespace, Service, p.service)
 }
 
 type deploymentPoder struct {
@@ -119,6 +138,10 @@ func (p deploymentPoder) Pod() (string, error) {
 	return PickRandomPod(p.k8sConfig, p.namespace, p.deployment, fetchPodsForDeployment)
 }
 
+func (p deploymentPoder) Pods() ([]string, error) {
+	return fetchPodsForDeployment(p.k8sConfig, p.namespace, p.deployment)
+}
+
 func (p deploymentPoder) Namespace() string {
 	return p.namespace
 }
@@ -128,7 +151,7 @@ func (p deploymentPoder) Ports() []string {
 }
 
 func (p deploymentPoder) String() string {
-	return fmt.Sprintf("%s/%s", p.namespace, p.deployment)
+	return fmt.Sprintf("%s/%s/%s", p.namespace, Deployment, p.deployment)
 }
 
 // fetchPodsForService gets all pods for a k8s service
@@ -168,6 +191,97 @@ func fetchPodsForService(config *rest.Config, namespace, service string) ([]stri
 	return pods, nil
 }
 
+// fetchTargetPorts returns the ports of the pod the ports of the service are forwarded to,
+// by service port. Named target ports are looked up in the containers of the pod, ports
+// the pod doesn't name are left out.
+func fetchTargetPorts(config *rest.Config, namespace, service, pod string) (map[uint16]uint16, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating k8s clientset: %w", err)
+	}
+
+	svc, err := clientset.CoreV1().Services(namespace).Get(context.Background(), service, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error finding service: %s/%s: %w", namespace, service, err)
+	}
+
+	var p *v1.Pod
+	ports := make(map[uint16]uint16, len(svc.Spec.Ports))
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+			continue
+		}
+
+		switch {
+		case port.TargetPort.Type == intstr.String:
+			if p == nil {
+				if p, err = clientset.CoreV1().Pods(namespace).Get(context.Background(), pod, metav1.GetOptions{}); err != nil {
+					return nil, fmt.Errorf("error getting pod %s/%s: %w", namespace, pod, err)
+				}
+			}
+			if target, ok := containerPort(p, port.TargetPort.StrVal); ok {
+				ports[uint16(port.Port)] = target
+			}
+		case port.TargetPort.IntVal == 0:
+			// the target port defaults to the port
+			ports[uint16(port.Port)] = uint16(port.Port)
+		default:
+			ports[uint16(port.Port)] = uint16(port.TargetPort.IntVal)
+		}
+	}
+
+	return ports, nil
+}
+
+// containerPort returns the TCP port of the containers of the pod named name
+func containerPort(pod *v1.Pod, name string) (uint16, bool) {
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.Name == name && (port.Protocol == "" || port.Protocol == v1.ProtocolTCP) {
+				return uint16(port.ContainerPort), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// fetchPodIP returns the address of the pod, it's empty until the pod is scheduled
+func fetchPodIP(config *rest.Config, namespace, pod string) (string, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("error creating k8s clientset: %w", err)
+	}
+
+	p, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), pod, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting pod %s/%s: %w", namespace, pod, err)
+	}
+
+	return p.Status.PodIP, nil
+}
+
+// fetchPodByIP returns the name of the running pod with the address ip
+func fetchPodByIP(config *rest.Config, namespace, ip string) (string, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("error creating k8s clientset: %w", err)
+	}
+
+	podList, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "status.podIP=" + ip + ",status.phase=Running",
+	})
+	if err != nil {
+		return "", fmt.Errorf("error fetching pods with address %s: %w", ip, err)
+	}
+
+	if len(podList.Items) == 0 {
+		return "", fmt.Errorf("no running pod with address %s in namespace %s", ip, namespace)
+	}
+
+	return podList.Items[0].Name, nil
+}
+
 func fetchPodsForDeployment(config *rest.Config, namespace, deployment string) ([]string, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -216,6 +330,31 @@ func PickRandomPod(config *rest.Config, namespace, svc string, fetchPodsFunc fun
 	return pickRandom(pods), nil
 }
 
+// pickPod picks a random pod of the poder, avoid is picked only if it's the only pod
+func pickPod(poder Poder, avoid string) (string, error) {
+	pods, err := poder.Pods()
+	if err != nil {
+		return "", fmt.Errorf("error fetching pod names: %w", err)
+	}
+
+	var candidates []string
+	for _, pod := range pods {
+		if pod != avoid {
+			candidates = append(candidates, pod)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = pods
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no pods found")
+	}
+
+	return pickRandom(candidates), nil
+}
+
 func pickRandom[T any](slice []T) T {
 	if len(slice) == 0 {
 		panic("Empty slice")
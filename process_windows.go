@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code of processes which are still running
+const stillActive = 259
+
+// processAlive returns true if a process with the given pid is running
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// e.g. a process of another user
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer func() {
+		_ = windows.CloseHandle(h)
+	}()
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// tunnelIdleTimeout is the time a tunnel without streams is kept open for the next connection
+	tunnelIdleTimeout = 5 * time.Minute
+	// resolveCacheTTL is the time the pod a host resolved to is reused for
+	resolveCacheTTL = 30 * time.Second
+)
+
+// TunnelPool opens streams to resources in the cluster on demand, e.g. for proxies. Hosts are
+// resolved to pods by their Poder, and tunnels to pods are shared by all streams to them.
+// Tunnels are closed once they weren't used for a while.
+type TunnelPool struct {
+	k8sConfig *rest.Config
+	// namespace is used for hosts without one
+	namespace   string
+	idleTimeout time.Duration
+
+	mu sync.Mutex
+	// resolved are the pods hosts resolved to, by resolvedKey
+	resolved map[string]resolvedPod
+	// tunnels are the open tunnels by namespace/pod
+	tunnels map[string]*pooledTunnel
+	closed  bool
+}
+
+type resolvedPod struct {
+	namespace, pod string
+	// ports are the ports of the pod by service port, nil if host named a pod
+	ports   map[uint16]uint16
+	expires time.Time
+}
+
+// pooledTunnel is a tunnel shared by streams, it's closed once it was idle for idleTimeout
+type pooledTunnel struct {
+	tunnel *podTunnel
+	// streams is the number of open streams
+	streams int
+	idle    *time.Timer
+}
+
+func NewTunnelPool(k8sConfig *rest.Config, namespace string) *TunnelPool {
+	return &TunnelPool{
+		k8sConfig:   k8sConfig,
+		namespace:   namespace,
+		idleTimeout: tunnelIdleTimeout,
+		resolved:    make(map[string]resolvedPod),
+		tunnels:     make(map[string]*pooledTunnel),
+	}
+}
+
+// PooledStream is a stream to a pod, the tunnel is released once it's closed
+type PooledStream struct {
+	*podStream
+	// Target is the pod the stream is connected to, e.g. ns/pod/api-7d9f
+	Target  string
+	once    sync.Once
+	release func()
+}
+
+func (s *PooledStream) Close() error {
+	err := s.podStream.Close()
+	s.once.Do(s.release)
+
+	return err
+}
+
+// Dial opens a stream to port of a pod of the resource named by host, e.g. api.payments
+// for a service or pod of namespace payments, api.payments.svc.cluster.local for a service,
+// or a DNS name of a pod, see ParsePodHost. Ports of services are mapped to their target port.
+func (p *TunnelPool) Dial(host string, port uint16) (*PooledStream, error) {
+	resolved, err := p.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	namespace, pod := resolved.namespace, resolved.pod
+
+	if resolved.ports != nil {
+		target, ok := resolved.ports[port]
+		if !ok {
+			return nil, fmt.Errorf("service %s has no TCP port %d served by pod %s", host, port, pod)
+		}
+		port = target
+	}
+
+	key := namespace + "/" + pod
+	t, err := p.acquire(namespace, pod)
+	if err != nil {
+		p.forget(host, nil)
+		return nil, err
+	}
+
+	stream, err := t.tunnel.Dial(port)
+	if err != nil {
+		// the tunnel is likely broken, the next connection starts over
+		p.release(key, t)
+		p.forget(host, t)
+		return nil, err
+	}
+
+	return &PooledStream{
+		podStream: stream,
+		Target:    fmt.Sprintf("%s/%s/%s", namespace, Pod, pod),
+		release: func() {
+			p.release(key, t)
+		},
+	}, nil
+}
+
+// resolve returns the pod host resolves to, services are preferred over pods of the same name.
+func (p *TunnelPool) resolve(host string) (resolvedPod, error) {
+	key, err := p.resolvedKey(host)
+	if err != nil {
+		return resolvedPod{}, err
+	}
+
+	p.mu.Lock()
+	cached, ok := p.resolved[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	var namespace, pod string
+	var ports map[uint16]uint16
+
+	if name, ip, podNamespace, ok := ParsePodHost(host); ok {
+		namespace = podNamespace
+		if ip != "" {
+			pod, err = fetchPodByIP(p.k8sConfig, namespace, ip)
+		} else {
+			// pods of stateful sets are named after their hostname
+			pod, err = pickPod(NewPoder(p.k8sConfig, Resource{Type: Pod, Namespace: namespace, Name: name}), "")
+		}
+	} else {
+		name, hostNamespace, service, _ := ParseClusterHost(host)
+		namespace = hostNamespace
+		if namespace == "" {
+			namespace = p.namespace
+		}
+
+		pod, err = pickPod(NewPoder(p.k8sConfig, Resource{Type: Service, Namespace: namespace, Name: name}), "")
+		if err == nil {
+			// services forward their ports to the target ports of their pods
+			ports, err = fetchTargetPorts(p.k8sConfig, namespace, name, pod)
+		} else if !service && k8serrors.IsNotFound(err) {
+			pod, err = pickPod(NewPoder(p.k8sConfig, Resource{Type: Pod, Namespace: namespace, Name: name}), "")
+		}
+	}
+	if err != nil {
+		return resolvedPod{}, fmt.Errorf("error resolving %s: %w", host, err)
+	}
+
+	resolved := resolvedPod{namespace: namespace, pod: pod, ports: ports, expires: time.Now().Add(resolveCacheTTL)}
+	p.mu.Lock()
+	p.resolved[key] = resolved
+	p.mu.Unlock()
+
+	return resolved, nil
+}
+
+// resolvedKey returns the key of the resource host names, hosts naming services can't resolve to pods
+func (p *TunnelPool) resolvedKey(host string) (string, error) {
+	if name, ip, namespace, ok := ParsePodHost(host); ok {
+		return fmt.Sprintf("%s/%s/%s%s", namespace, Pod, name, ip), nil
+	}
+
+	name, namespace, service, err := ParseClusterHost(host)
+	if err != nil {
+		return "", err
+	}
+	if namespace == "" {
+		namespace = p.namespace
+	}
+	if service {
+		return fmt.Sprintf("%s/%s/%s", namespace, Service, name), nil
+	}
+
+	return namespace + "/" + name, nil
+}
+
+// forget drops the pod host resolved to and closes the tunnel t, if any, so both are
+// set up again by the next connection.
+func (p *TunnelPool) forget(host string, t *pooledTunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, err := p.resolvedKey(host); err == nil {
+		delete(p.resolved, key)
+	}
+
+	if t != nil {
+		for key, pooled := range p.tunnels {
+			if pooled == t {
+				delete(p.tunnels, key)
+			}
+		}
+		_ = t.tunnel.Close()
+	}
+}
+
+// acquire returns an open tunnel to the pod, a new one is dialed if there is none
+func (p *TunnelPool) acquire(namespace, pod string) (*pooledTunnel, error) {
+	key := namespace + "/" + pod
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("tunnel pool is closed")
+	}
+	if t, ok := p.tunnels[key]; ok && !isDone(t.tunnel) {
+		p.use(t)
+		p.mu.Unlock()
+		return t, nil
+	}
+	p.mu.Unlock()
+
+	// dialed without holding the lock, connections to other pods aren't blocked meanwhile
+	tunnel, err := dialPod(p.k8sConfig, namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = tunnel.Close()
+		return nil, errors.New("tunnel pool is closed")
+	}
+	if t, ok := p.tunnels[key]; ok && !isDone(t.tunnel) {
+		// dialed concurrently
+		_ = tunnel.Close()
+		p.use(t)
+		return t, nil
+	}
+
+	t := &pooledTunnel{tunnel: tunnel}
+	p.tunnels[key] = t
+	p.use(t)
+
+	return t, nil
+}
+
+// use counts a stream of the tunnel, must be called with p.mu held
+func (p *TunnelPool) use(t *pooledTunnel) {
+	t.streams++
+	if t.idle != nil {
+		t.idle.Stop()
+		t.idle = nil
+	}
+}
+
+// release uncounts a stream of the tunnel, it's closed once it was idle for idleTimeout
+func (p *TunnelPool) release(key string, t *pooledTunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.streams--
+	if t.streams > 0 {
+		return
+	}
+
+	t.idle = time.AfterFunc(p.idleTimeout, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if t.streams > 0 {
+			return
+		}
+		if p.tunnels[key] == t {
+			delete(p.tunnels, key)
+		}
+		_ = t.tunnel.Close()
+	})
+}
+
+// Tunnels returns the number of open tunnels
+func (p *TunnelPool) Tunnels() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.tunnels)
+}
+
+// Close closes all tunnels, streams over them are reset.
+func (p *TunnelPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for key, t := range p.tunnels {
+		if t.idle != nil {
+			t.idle.Stop()
+		}
+		_ = t.tunnel.Close()
+		delete(p.tunnels, key)
+	}
+}
+
+// isDone returns true if the tunnel was closed
+func isDone(t *podTunnel) bool {
+	select {
+	case <-t.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// proxyConnection splices the client connection with the stream and reports it, both are closed afterward.
+func proxyConnection(client net.Conn, stream *PooledStream, target string, reportChan chan<- Report) {
+	defer func() {
+		_ = client.Close()
+	}()
+
+	started := time.Now()
+	var bytesIn, bytesOut atomic.Uint64
+	_, err := splice(client, stream.podStream,
+		func(p []byte) { bytesIn.Add(uint64(len(p))) },
+		func(p []byte) { bytesOut.Add(uint64(len(p))) },
+	)
+	if closeErr := stream.Close(); err == nil {
+		err = closeErr
+	}
+
+	report := NewReport(SeverityDebug, nil, "closed connection from %s to %s (%s) after %s, %d bytes in, %d bytes out",
+		client.RemoteAddr(), target, stream.Target, time.Since(started).Round(time.Millisecond), bytesIn.Load(), bytesOut.Load()).WithComponent(ComponentProxy)
+	if err != nil {
+		report = report.WithErr(err)
+	}
+	reportChan <- report
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readyDocument is printed and written to the ready file once all forwards are ready
+type readyDocument struct {
+	Ready    bool            `json:"ready"`
+	Forwards []ForwardStatus `json:"forwards"`
+}
+
+// readyGate signals once all forwards are ready, by a line or JSON document
+// written to out and by the ready file, if any.
+type readyGate struct {
+	forwarder *Forwarder
+	// out is written to unless it's nil
+	out    io.Writer
+	format LogFormat
+	// file is written atomically unless it's empty
+	file string
+}
+
+// Wait blocks until all forwards are ready and signals it, the error of ctx being done
+// names the forwards which never became ready.
+func (g readyGate) Wait(ctx context.Context) error {
+	if err := g.forwarder.WaitReady(ctx); err != nil {
+		return err
+	}
+
+	doc := readyDocument{Ready: true, Forwards: g.forwarder.Status()}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if g.file != "" {
+		if err := writeFileAtomic(g.file, append(data, '\n')); err != nil {
+			return fmt.Errorf("error writing ready file: %w", err)
+		}
+	}
+
+	if g.out == nil {
+		return nil
+	}
+
+	if g.format == LogFormatJSON {
+		_, err = fmt.Fprintf(g.out, "%s\n", data)
+		return err
+	}
+
+	return writeReadyLine(g.out, doc.Forwards)
+}
+
+// Remove removes the ready file, so it doesn't claim readiness of a stopped instance
+func (g readyGate) Remove() {
+	if g.file != "" {
+		_ = os.Remove(g.file)
+	}
+}
+
+// writeReadyLine writes a single line naming all forwards and their local addresses, e.g.
+// ready: ns/service/foo 127.0.0.1:8080, ns/pod/bar 127.0.0.1:9090
+func writeReadyLine(w io.Writer, statuses []ForwardStatus) error {
+	forwards := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		address := "-"
+		if len(s.LocalAddresses) > 0 {
+			address = s.LocalAddresses[0]
+		}
+		forwards = append(forwards, s.Resource.Key()+" "+address)
+	}
+
+	_, err := fmt.Fprintf(w, "ready: %s\n", strings.Join(forwards, ", "))
+	return err
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it,
+// so readers see either no file or the complete content.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a no-op once renamed
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyGate(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "ready.json")
+	var out bytes.Buffer
+	gate := readyGate{forwarder: forwarder, out: &out, format: LogFormatJSON, file: file}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := gate.Wait(ctx); err != nil {
+		t.Fatalf("Wait() returned an error: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("error reading ready file: %v", err)
+	}
+	if !bytes.Equal(data, out.Bytes()) {
+		t.Errorf("ready file contains %s, want the printed document %s", data, out.Bytes())
+	}
+
+	var doc readyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("error parsing %s: %v", data, err)
+	}
+	if !doc.Ready || len(doc.Forwards) != 1 || doc.Forwards[0].State != StateReady || len(doc.Forwards[0].LocalAddresses) == 0 {
+		t.Errorf("got %+v, want a single ready forward", doc)
+	}
+
+	gate.Remove()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("ready file still exists after Remove()")
+	}
+}
+
+func TestReadyGateTimeout(t *testing.T) {
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "missing", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "ready.json")
+	var out bytes.Buffer
+	gate := readyGate{forwarder: forwarder, out: &out, file: file}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err := gate.Wait(ctx)
+	if !errors.Is(err, ErrForwardNotReady) || !strings.Contains(err.Error(), "ns/pod/missing:0:80") {
+		t.Errorf("Wait() returned %v, want ns/pod/missing:0:80 not to be ready", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("printed %q although forwards aren't ready", out.String())
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("ready file was written although forwards aren't ready")
+	}
+}
+
+func TestWriteReadyLine(t *testing.T) {
+	statuses := []ForwardStatus{
+		{Resource: Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"}, LocalAddresses: []string{"127.0.0.1:8080", "[::1]:8080"}},
+		{Resource: Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeReadyLine(&buf, statuses); err != nil {
+		t.Fatalf("writeReadyLine() returned an error: %v", err)
+	}
+
+	if want := "ready: ns/service/foo 127.0.0.1:8080, ns/pod/bar -\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ready")
+
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatalf("writeFileAtomic() returned an error: %v", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != content {
+			t.Errorf("got %q, want %q", data, content)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want no temporary files left", len(entries))
+	}
+}
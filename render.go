@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// envFileTemplate renders the variables of all forwards as env file
+const envFileTemplate = `{{ range .Forwards -}}
+{{ .Name }}_HOST={{ .Host }}
+{{ .Name }}_PORT={{ .Port }}
+{{ .Name }}_ADDR={{ .Address }}
+{{ end -}}
+`
+
+// templateForward describes the local endpoint of a forward in templates
+type templateForward struct {
+	// Name is the prefix of the variables derived for the forward, e.g. PAYMENTS_API
+	Name       string
+	ID         string
+	Resource   string
+	Namespace  string
+	Type       string
+	Pod        string
+	Host       string
+	Port       uint16
+	RemotePort uint16
+	// Address is the local host and port, e.g. 127.0.0.1:8080
+	Address string
+}
+
+// templateData is passed to templates, e.g. {{ .Env.PAYMENTS_API_PORT }} or {{ range .Forwards }}...
+type templateData struct {
+	Forwards []templateForward
+	// Env are the variables derived for all forwards, NAME_HOST, NAME_PORT and NAME_ADDR
+	Env map[string]string
+}
+
+// renderTarget is a template rendered to a file or stdout
+type renderTarget struct {
+	tpl *template.Template
+	// path is written atomically, stdout is used if empty
+	path string
+	// last is the last rendered content, it's only written if it changed
+	last []byte
+}
+
+// Renderer renders the local endpoints of all ready forwards through templates, whenever
+// they change. It's an Observer of the Forwarder.
+type Renderer struct {
+	targets    []*renderTarget
+	stdout     io.Writer
+	reportChan chan<- Report
+	changed    chan struct{}
+}
+
+var _ Observer = &Renderer{}
+
+func NewRenderer(reportChan chan<- Report) *Renderer {
+	return &Renderer{
+		stdout:     os.Stdout,
+		reportChan: reportChan,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// AddEnvFile renders the variables of all forwards to the env file at path
+func (r *Renderer) AddEnvFile(path string) {
+	r.targets = append(r.targets, &renderTarget{
+		tpl:  template.Must(template.New("env").Parse(envFileTemplate)),
+		path: path,
+	})
+}
+
+// AddTemplate renders the template at tplPath to path, or stdout if path is empty
+func (r *Renderer) AddTemplate(tplPath, path string) error {
+	content, err := os.ReadFile(tplPath)
+	if err != nil {
+		return fmt.Errorf("error reading template: %w", err)
+	}
+
+	// unknown variables are errors instead of empty values
+	tpl, err := template.New(filepath.Base(tplPath)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("error parsing template: %w", err)
+	}
+
+	r.targets = append(r.targets, &renderTarget{tpl: tpl, path: path})
+	return nil
+}
+
+func (r *Renderer) StateChanged(status ForwardStatus, _ ForwardState) {
+	switch status.State {
+	case StateReady, StateFailed, StateStopped:
+		select {
+		case r.changed <- struct{}{}:
+		default:
+			// already pending
+		}
+	}
+}
+
+func (r *Renderer) PodResolved(ForwardStatus, time.Duration) {}
+
+func (r *Renderer) ConnectionClosed(ForwardStatus, ConnectionStats) {}
+
+// Run renders all templates whenever a forward changed, until stopChan is closed.
+func (r *Renderer) Run(forwarder *Forwarder, stopChan <-chan struct{}) {
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-r.changed:
+		}
+
+		if err := r.Render(forwarder.Status()); err != nil {
+			r.reportChan <- NewReport(SeverityError, nil, "error rendering templates").WithComponent(ComponentTemplate).WithErr(err)
+		}
+	}
+}
+
+// Render renders all templates with the given forwards, unchanged output isn't written again.
+func (r *Renderer) Render(statuses []ForwardStatus) error {
+	data := newTemplateData(statuses)
+
+	for _, t := range r.targets {
+		var buf bytes.Buffer
+		if err := t.tpl.Execute(&buf, data); err != nil {
+			return err
+		}
+
+		if t.last != nil && bytes.Equal(buf.Bytes(), t.last) {
+			continue
+		}
+
+		if t.path == "" {
+			if _, err := r.stdout.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		} else if err := writeFileAtomic(t.path, buf.Bytes()); err != nil {
+			return err
+		}
+		t.last = buf.Bytes()
+
+		name := t.path
+		if name == "" {
+			name = "stdout"
+		}
+		r.reportChan <- NewReport(SeverityDebug, nil, "rendered %s", name).WithComponent(ComponentTemplate)
+	}
+
+	return nil
+}
+
+// newTemplateData describes all forwards which were ready and weren't stopped since
+func newTemplateData(statuses []ForwardStatus) templateData {
+	var forwards []templateForward
+	for _, s := range statuses {
+		if s.ReadyAt.IsZero() || s.State == StateFailed || s.State == StateStopped || len(s.Ports) == 0 || len(s.LocalAddresses) == 0 {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(s.LocalAddresses[0])
+		if err != nil {
+			continue
+		}
+
+		forwards = append(forwards, templateForward{
+			ID:         s.ID,
+			Resource:   s.Resource.Key(),
+			Namespace:  s.Resource.Namespace,
+			Type:       string(s.Resource.Type),
+			Pod:        s.Pod,
+			Host:       host,
+			Port:       s.Ports[0].Local,
+			RemotePort: s.Ports[0].Remote,
+			Address:    s.LocalAddresses[0],
+		})
+	}
+
+	assignNames(forwards)
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].Name < forwards[j].Name
+	})
+
+	env := make(map[string]string, 3*len(forwards))
+	for _, f := range forwards {
+		env[f.Name+"_HOST"] = f.Host
+		env[f.Name+"_PORT"] = strconv.Itoa(int(f.Port))
+		env[f.Name+"_ADDR"] = f.Address
+	}
+
+	return templateData{Forwards: forwards, Env: env}
+}
+
+// assignNames derives the variable names of the forwards from their resource names, e.g.
+// PAYMENTS_API for service/payments-api. Names used by several forwards are prefixed by
+// the namespace, and suffixed by the remote port if still ambiguous. Forwards of the same
+// resource and remote port, or of resources of different types, are named after their ID.
+func assignNames(forwards []templateForward) {
+	name := func(f templateForward) string {
+		return f.Resource[strings.LastIndex(f.Resource, "/")+1:]
+	}
+	derive := []func(f templateForward) string{
+		name,
+		func(f templateForward) string { return f.Namespace + "_" + name(f) },
+		func(f templateForward) string {
+			return f.Namespace + "_" + name(f) + "_" + strconv.Itoa(int(f.RemotePort))
+		},
+		// e.g. ORDERS_SERVICE_DB_5432_5432
+		func(f templateForward) string { return f.ID },
+	}
+
+	level := make([]int, len(forwards))
+	for {
+		names := make(map[string]int)
+		for i, f := range forwards {
+			forwards[i].Name = envName(derive[level[i]](f))
+			names[forwards[i].Name]++
+		}
+
+		ambiguous := false
+		for i, f := range forwards {
+			if names[f.Name] > 1 && level[i] < len(derive)-1 {
+				level[i]++
+				ambiguous = true
+			}
+		}
+		if !ambiguous {
+			break
+		}
+	}
+
+	// IDs are unique, but e.g. pod names with dots may map to the same variable name
+	seen := make(map[string]int)
+	for i, f := range forwards {
+		if seen[f.Name]++; seen[f.Name] > 1 {
+			forwards[i].Name = f.Name + "_" + strconv.Itoa(seen[f.Name])
+		}
+	}
+}
+
+// envName turns s into an environment variable name, e.g. payments-api becomes PAYMENTS_API
+func envName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+
+	if name != "" && unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+
+	return name
+}
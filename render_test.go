@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readyStatus(resource Resource, local uint16, address string) ForwardStatus {
+	port, _ := ParsePorts(resource.Ports)
+	port.Local = local
+
+	return ForwardStatus{
+		ID:             resource.String(),
+		Resource:       resource,
+		State:          StateReady,
+		Ports:          []ForwardedPort{port},
+		LocalAddresses: []string{address},
+		ReadyAt:        time.Now(),
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	tplFile := filepath.Join(dir, "config.tpl")
+	out := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(tplFile, []byte("db: {{ .Env.DB_ADDR }}\n{{ range .Forwards }}- {{ .Resource }} -> {{ .Port }}\n{{ end }}"), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	r := NewRenderer(drainReports(t))
+	r.stdout = &stdout
+	r.AddEnvFile(envFile)
+	if err := r.AddTemplate(tplFile, out); err != nil {
+		t.Fatalf("AddTemplate() returned an error: %v", err)
+	}
+	if err := r.AddTemplate(tplFile, ""); err != nil {
+		t.Fatalf("AddTemplate() returned an error: %v", err)
+	}
+
+	db := readyStatus(Resource{Type: Service, Namespace: "ns", Name: "db", Ports: "0:5432"}, 40000, "127.0.0.1:40000")
+	api := readyStatus(Resource{Type: Deployment, Namespace: "payments", Name: "payments-api", Ports: "8080:80"}, 8080, "127.0.0.1:8080")
+	// not ready yet, left out
+	cache := ForwardStatus{ID: "ns/service/cache:6379:6379", Resource: Resource{Type: Service, Namespace: "ns", Name: "cache", Ports: "6379:6379"}, State: StateConnecting}
+
+	if err := r.Render([]ForwardStatus{db, api, cache}); err != nil {
+		t.Fatalf("Render() returned an error: %v", err)
+	}
+
+	wantEnv := "DB_HOST=127.0.0.1\nDB_PORT=40000\nDB_ADDR=127.0.0.1:40000\n" +
+		"PAYMENTS_API_HOST=127.0.0.1\nPAYMENTS_API_PORT=8080\nPAYMENTS_API_ADDR=127.0.0.1:8080\n"
+	if data, _ := os.ReadFile(envFile); string(data) != wantEnv {
+		t.Errorf("env file contains:\n%s\nwant:\n%s", data, wantEnv)
+	}
+
+	wantOut := "db: 127.0.0.1:40000\n- ns/service/db -> 40000\n- payments/deployment/payments-api -> 8080\n"
+	if data, _ := os.ReadFile(out); string(data) != wantOut {
+		t.Errorf("template output contains:\n%s\nwant:\n%s", data, wantOut)
+	}
+	if stdout.String() != wantOut {
+		t.Errorf("printed:\n%s\nwant:\n%s", stdout.String(), wantOut)
+	}
+
+	// unchanged output isn't printed again, a new local port is
+	_ = r.Render([]ForwardStatus{db, api})
+	if stdout.String() != wantOut {
+		t.Errorf("unchanged output was printed again:\n%s", stdout.String())
+	}
+
+	db = readyStatus(db.Resource, 40001, "127.0.0.1:40001")
+	_ = r.Render([]ForwardStatus{db, api})
+	if data, _ := os.ReadFile(envFile); !bytes.Contains(data, []byte("DB_PORT=40001\n")) {
+		t.Errorf("env file wasn't updated:\n%s", data)
+	}
+}
+
+func TestRendererUnknownVariable(t *testing.T) {
+	tplFile := filepath.Join(t.TempDir(), "config.tpl")
+	if err := os.WriteFile(tplFile, []byte("{{ .Env.MISSING_PORT }}"), 0o600); err != nil {
+		t.Fatalf("error writing template: %v", err)
+	}
+
+	r := NewRenderer(drainReports(t))
+	r.stdout = &bytes.Buffer{}
+	if err := r.AddTemplate(tplFile, ""); err != nil {
+		t.Fatalf("AddTemplate() returned an error: %v", err)
+	}
+
+	if err := r.Render(nil); err == nil {
+		t.Errorf("Render() succeeded with an unknown variable")
+	}
+}
+
+func TestAssignNames(t *testing.T) {
+	forwards := []templateForward{
+		{Resource: "payments/service/api", Namespace: "payments", RemotePort: 80},
+		{Resource: "orders/service/api", Namespace: "orders", RemotePort: 80},
+		{Resource: "orders/service/db", Namespace: "orders", RemotePort: 5432},
+		{Resource: "orders/service/db", Namespace: "orders", RemotePort: 5433},
+		{Resource: "ns/pod/1st-pod", Namespace: "ns", RemotePort: 80},
+		// the same resource and remote port on different local ports
+		{ID: "web/service/app:8080:80", Resource: "web/service/app", Namespace: "web", RemotePort: 80},
+		{ID: "web/service/app:8081:80", Resource: "web/service/app", Namespace: "web", RemotePort: 80},
+		// resources of different types with the same name
+		{ID: "web/service/cache:6379:6379", Resource: "web/service/cache", Namespace: "web", RemotePort: 6379},
+		{ID: "web/deployment/cache:6380:6379", Resource: "web/deployment/cache", Namespace: "web", RemotePort: 6379},
+		// pod names which map to the same variable name
+		{ID: "ns/pod/a.b:0:80", Resource: "ns/pod/a.b", Namespace: "ns", RemotePort: 80},
+		{ID: "ns/pod/a-b:0:80", Resource: "ns/pod/a-b", Namespace: "ns", RemotePort: 80},
+	}
+	assignNames(forwards)
+
+	var got []string
+	for _, f := range forwards {
+		got = append(got, f.Name)
+	}
+
+	want := []string{
+		"PAYMENTS_API", "ORDERS_API", "ORDERS_DB_5432", "ORDERS_DB_5433", "_1ST_POD",
+		"WEB_SERVICE_APP_8080_80", "WEB_SERVICE_APP_8081_80",
+		"WEB_SERVICE_CACHE_6379_6379", "WEB_DEPLOYMENT_CACHE_6380_6379",
+		"NS_POD_A_B_0_80", "NS_POD_A_B_0_80_2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+}
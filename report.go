@@ -1,15 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"strings"
+	"time"
 )
 
 type Severity int
 
-var currentSeverity = SeverityInfo
-
 const (
 	SeverityTrace Severity = iota
 	SeverityDebug
@@ -52,46 +52,256 @@ func (s Severity) String() string {
 	}
 }
 
+// LevelTrace is the slog level of SeverityTrace, slog has no trace level itself
+const LevelTrace = slog.Level(-8)
+
+// Level returns the slog level of the severity
+func (s Severity) Level() slog.Level {
+	switch s {
+	case SeverityTrace:
+		return LevelTrace
+	case SeverityDebug:
+		return slog.LevelDebug
+	case SeverityWarning:
+		return slog.LevelWarn
+	case SeverityError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// SeverityFromLevel returns the severity of the given slog level
+func SeverityFromLevel(level slog.Level) Severity {
+	switch {
+	case level < slog.LevelDebug:
+		return SeverityTrace
+	case level < slog.LevelInfo:
+		return SeverityDebug
+	case level < slog.LevelWarn:
+		return SeverityInfo
+	case level < slog.LevelError:
+		return SeverityWarning
+	default:
+		return SeverityError
+	}
+}
+
+// LogFormat is the format reports are logged in
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+func LogFormatFromString(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(s)); f {
+	case LogFormatText, LogFormatJSON:
+		return f, nil
+	default:
+		return LogFormatText, fmt.Errorf("unknown log format: %s", s)
+	}
+}
+
+// EventType describes what a report is about
+type EventType string
+
+const (
+	// EventMessage is a report without a more specific type
+	EventMessage EventType = "message"
+	// EventState is a state change of a forward
+	EventState EventType = "state"
+	// EventConnection is about a single local connection of a forward
+	EventConnection EventType = "connection"
+	// EventConfig is about applying the config file
+	EventConfig EventType = "config"
+	// EventCommand is about executing a runtime command
+	EventCommand EventType = "command"
+)
+
+// Component is the part of multiforward a report originates from
+type Component string
+
+const (
+	ComponentMain      Component = "main"
+	ComponentForwarder Component = "forwarder"
+	ComponentConfig    Component = "config"
+	ComponentCommand   Component = "command"
+	ComponentMetrics   Component = "metrics"
+	ComponentAdmin     Component = "admin"
+	ComponentHooks     Component = "hooks"
+	ComponentTemplate  Component = "template"
+	ComponentSystemd   Component = "systemd"
+	ComponentHosts     Component = "hosts"
+	ComponentDNS       Component = "dns"
+	ComponentProxy     Component = "proxy"
+	ComponentTLS       Component = "tls"
+	ComponentCapture   Component = "capture"
+)
+
 type Report struct {
-	Severity Severity
-	Message  string
+	Time      time.Time
+	Severity  Severity
+	Event     EventType
+	Component Component
+	// Resource, Namespace and Ports describe the forward the report is about, if any
+	Resource  string
+	Namespace string
+	Ports     []string
+	// Pod is the pod the forward is connected to, if known
+	Pod     string
+	Err     error
+	Message string
 }
 
 func (r Report) String() string {
-	return r.Message
+	prefix := fmt.Sprintf("[%s] ", r.Severity)
+	if r.Resource != "" {
+		prefix = fmt.Sprintf("[%s] [%s] ", r.Severity, r.Resource)
+	}
+
+	if r.Err != nil {
+		return prefix + r.Message + ": " + r.Err.Error()
+	}
+
+	return prefix + r.Message
 }
 
 func NewReport(severity Severity, poder Poder, format string, a ...any) Report {
-	prefix := fmt.Sprintf("[%s] ", severity)
-	if poder != nil {
-		prefix = fmt.Sprintf("[%s] [%s] ", severity, poder)
+	r := Report{
+		Time:      time.Now(),
+		Severity:  severity,
+		Event:     EventMessage,
+		Component: ComponentMain,
+		Message:   fmt.Sprintf(format, a...),
 	}
 
-	return Report{
-		Severity: severity,
-		Message:  prefix + fmt.Sprintf(format, a...),
+	if poder != nil {
+		r.Resource = poder.String()
+		r.Namespace = poder.Namespace()
+		r.Ports = poder.Ports()
 	}
+
+	return r
 }
 
-const Reset = "\033[0m"
-const Red = "\033[31m"
-const Green = "\033[32m"
-const Yellow = "\033[33m"
-const Cyan = "\033[36m"
+// WithEvent returns a copy of the report with the given event type
+func (r Report) WithEvent(event EventType) Report {
+	r.Event = event
+	return r
+}
 
-func (r Report) Dump() {
-	if r.Severity < currentSeverity {
-		return
+// WithComponent returns a copy of the report with the given component
+func (r Report) WithComponent(component Component) Report {
+	r.Component = component
+	return r
+}
+
+// WithResource returns a copy of the report about the given resource
+func (r Report) WithResource(resource Resource) Report {
+	r.Resource = resource.Key()
+	r.Namespace = resource.Namespace
+	r.Ports = []string{resource.Ports}
+	return r
+}
+
+// WithPod returns a copy of the report with the given pod
+func (r Report) WithPod(pod string) Report {
+	r.Pod = pod
+	return r
+}
+
+// WithErr returns a copy of the report with the given error
+func (r Report) WithErr(err error) Report {
+	r.Err = err
+	return r
+}
+
+// Attrs returns the fields of the report as slog attributes, empty fields are omitted.
+func (r Report) Attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String(EventKey, string(r.Event)),
+		slog.String(ComponentKey, string(r.Component)),
 	}
 
-	switch r.Severity {
-	case SeverityInfo:
-		fmt.Println(Green + r.Message + Reset)
-	case SeverityWarning:
-		fmt.Println(Yellow + r.Message + Reset)
-	case SeverityError:
-		fmt.Fprintln(os.Stderr, Red+r.Message+Reset)
-	default:
-		fmt.Println(Cyan + r.Message + Reset)
+	if r.Resource != "" {
+		attrs = append(attrs, slog.String(ResourceKey, r.Resource))
 	}
+	if r.Namespace != "" {
+		attrs = append(attrs, slog.String(NamespaceKey, r.Namespace))
+	}
+	if r.Pod != "" {
+		attrs = append(attrs, slog.String(PodKey, r.Pod))
+	}
+	if len(r.Ports) > 0 {
+		attrs = append(attrs, slog.Any(PortsKey, r.Ports))
+	}
+	if r.Err != nil {
+		attrs = append(attrs, slog.String(ErrorKey, r.Err.Error()))
+	}
+
+	return attrs
+}
+
+// Log passes the report to the given logger.
+func (r Report) Log(logger *slog.Logger) {
+	ctx := context.Background()
+	level := r.Severity.Level()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	record := slog.NewRecord(r.Time, level, r.Message, 0)
+	record.AddAttrs(r.Attrs()...)
+	_ = logger.Handler().Handle(ctx, record)
+}
+
+// reportQueueSize is the number of reports queued for the log
+const reportQueueSize = 10000
+
+// queueReports passes the reports sent to in on to the returned channel. Up to size reports
+// are queued, so senders don't block while the receiver is busy, e.g. during startup. If the
+// receiver doesn't keep up, the oldest reports are dropped and a warning is passed on instead.
+func queueReports(in <-chan Report, size int) <-chan Report {
+	out := make(chan Report)
+
+	go func() {
+		defer close(out)
+
+		var queue []Report
+		dropped := 0
+		for in != nil || len(queue) > 0 || dropped > 0 {
+			// sends are enabled only if there is a report queued, dropped ones are reported first
+			var send chan<- Report
+			var next Report
+			switch {
+			case dropped > 0:
+				send, next = out, NewReport(SeverityWarning, nil, "dropped %d reports, the log didn't keep up", dropped)
+			case len(queue) > 0:
+				send, next = out, queue[0]
+			}
+
+			select {
+			case r, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if len(queue) >= size {
+					queue = queue[1:]
+					dropped++
+				}
+				queue = append(queue, r)
+			case send <- next:
+				if dropped > 0 {
+					dropped = 0
+				} else {
+					queue = queue[1:]
+				}
+			}
+		}
+	}()
+
+	return out
 }
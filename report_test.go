@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"reflect"
 	"testing"
 )
@@ -71,3 +72,120 @@ func TestSeverityFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestReportString(t *testing.T) {
+	poder := podPoder{namespace: "ns", pod: "foo", ports: []string{"8080:80"}}
+
+	tests := []struct {
+		name   string
+		report Report
+		want   string
+	}{
+		{
+			name:   "without poder",
+			report: NewReport(SeverityInfo, nil, "hello %s", "world"),
+			want:   "[INFO] hello world",
+		},
+		{
+			name:   "with poder",
+			report: NewReport(SeverityDebug, poder, "hello"),
+			want:   "[DEBUG] [ns/pod/foo] hello",
+		},
+		{
+			name:   "with error",
+			report: NewReport(SeverityError, poder, "port forwarding failed").WithErr(fmt.Errorf("pod not found")),
+			want:   "[ERROR] [ns/pod/foo] port forwarding failed: pod not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogFormatFromString(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    LogFormat
+		wantErr bool
+	}{
+		{s: "text", want: LogFormatText},
+		{s: "JSON", want: LogFormatJSON},
+		{s: "xml", want: LogFormatText, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := LogFormatFromString(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got log format = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeverityLevel(t *testing.T) {
+	for _, severity := range []Severity{SeverityTrace, SeverityDebug, SeverityInfo, SeverityWarning, SeverityError} {
+		t.Run(severity.String(), func(t *testing.T) {
+			if got := SeverityFromLevel(severity.Level()); got != severity {
+				t.Errorf("SeverityFromLevel(%v) = %v, want %v", severity.Level(), got, severity)
+			}
+		})
+	}
+
+	if got := SeverityFromLevel(slog.LevelWarn + 1); got != SeverityWarning {
+		t.Errorf("SeverityFromLevel(WARN+1) = %v, want %v", got, SeverityWarning)
+	}
+}
+
+func TestQueueReports(t *testing.T) {
+	in := make(chan Report)
+	out := queueReports(in, 1000)
+
+	// nothing is received until all reports are sent
+	for i := range 1000 {
+		in <- NewReport(SeverityInfo, nil, "report %d", i)
+	}
+	close(in)
+
+	i := 0
+	for r := range out {
+		if want := fmt.Sprintf("report %d", i); r.Message != want {
+			t.Fatalf("got report %q, want %q", r.Message, want)
+		}
+		i++
+	}
+	if i != 1000 {
+		t.Errorf("got %d reports, want 1000", i)
+	}
+}
+
+func TestQueueReportsDropsOldest(t *testing.T) {
+	in := make(chan Report)
+	out := queueReports(in, 10)
+
+	for i := range 25 {
+		in <- NewReport(SeverityInfo, nil, "report %d", i)
+	}
+	close(in)
+
+	var got []string
+	for r := range out {
+		got = append(got, r.Message)
+	}
+
+	want := []string{"dropped 15 reports, the log didn't keep up"}
+	for i := 15; i < 25; i++ {
+		want = append(want, fmt.Sprintf("report %d", i))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got reports %q, want %q", got, want)
+	}
+}
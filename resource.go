@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type ResourceType string
@@ -40,6 +44,42 @@ type Resource struct {
 	Ports     string
 }
 
+// Key returns the resource without its ports, e.g. "ns/service/name"
+func (r Resource) Key() string {
+	return fmt.Sprintf("%s/%s/%s", r.Namespace, r.Type, r.Name)
+}
+
+// Names returns the names the resource can be selected by, most specific first, e.g.
+// "ns/service/name", "service/name" and "name"
+func (r Resource) Names() []string {
+	return []string{r.Key(), string(r.Type) + "/" + r.Name, r.Name}
+}
+
+// String returns the resource in the same format as accepted by ParseResource
+func (r Resource) String() string {
+	return fmt.Sprintf("%s:%s", r.Key(), r.Ports)
+}
+
+// ParsePorts parses a port pair in the format localPort:remotePort
+func ParsePorts(s string) (ForwardedPort, error) {
+	local, remote, ok := strings.Cut(s, ":")
+	if !ok {
+		return ForwardedPort{}, fmt.Errorf("invalid ports format: %s", s)
+	}
+
+	localPort, err := strconv.ParseUint(local, 10, 16)
+	if err != nil {
+		return ForwardedPort{}, fmt.Errorf("invalid local port: %s", local)
+	}
+
+	remotePort, err := strconv.ParseUint(remote, 10, 16)
+	if err != nil || remotePort == 0 {
+		return ForwardedPort{}, fmt.Errorf("invalid remote port: %s", remote)
+	}
+
+	return ForwardedPort{Local: uint16(localPort), Remote: uint16(remotePort)}, nil
+}
+
 // ParseResource parses given string into a Resource
 func ParseResource(s string) (Resource, error) {
 	re := regexp.MustCompile(`((\S+)/)?(service|pod|deployment)/(\S+):(\d+:\d+)`)
@@ -64,3 +104,56 @@ func ParseResource(s string) (Resource, error) {
 		Ports:     ports,
 	}, nil
 }
+
+// clusterDomain is the domain of the cluster, services are named <svc>.<ns>.svc.<clusterDomain>
+const clusterDomain = "cluster.local"
+
+// ParseClusterHost splits the host name of a resource in the cluster into its name and namespace,
+// e.g. api.payments.svc.cluster.local, api.payments.svc, api.payments or api. The namespace is empty
+// for short names, service is set if the host names a service explicitly.
+func ParseClusterHost(host string) (name, namespace string, service bool, err error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, suffix := range []string{".svc." + clusterDomain, ".svc"} {
+		if trimmed, ok := strings.CutSuffix(host, suffix); ok {
+			host, service = trimmed, true
+			break
+		}
+	}
+
+	name, namespace, qualified := strings.Cut(host, ".")
+	if name == "" || strings.Contains(namespace, ".") || (qualified && namespace == "") || (service && !qualified) {
+		return "", "", false, fmt.Errorf("invalid cluster host: %s", host)
+	}
+
+	return name, namespace, service, nil
+}
+
+// ParsePodHost splits the DNS name of a pod into its namespace and either its address, e.g.
+// 10-1-2-3.payments.pod.cluster.local, or its hostname in the subdomain of a headless service,
+// e.g. db-0.db.payments.svc.cluster.local. ok is false for hosts not naming a pod.
+func ParsePodHost(host string) (name, ip, namespace string, ok bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, suffix := range []string{".pod." + clusterDomain, ".pod"} {
+		if trimmed, found := strings.CutSuffix(host, suffix); found {
+			dashed, namespace, _ := strings.Cut(trimmed, ".")
+			addr := net.ParseIP(strings.ReplaceAll(dashed, "-", "."))
+			if addr == nil || addr.To4() == nil || namespace == "" || strings.Contains(namespace, ".") {
+				return "", "", "", false
+			}
+			return "", addr.String(), namespace, true
+		}
+	}
+
+	for _, suffix := range []string{".svc." + clusterDomain, ".svc"} {
+		if trimmed, found := strings.CutSuffix(host, suffix); found {
+			labels := strings.Split(trimmed, ".")
+			if len(labels) != 3 || slices.Contains(labels, "") {
+				return "", "", "", false
+			}
+			return labels[0], "", labels[2], true
+		}
+	}
+
+	return "", "", "", false
+}
@@ -152,3 +152,65 @@ func TestParseResource(t *testing.T) {
 		})
 	}
 }
+
+func TestParseClusterHost(t *testing.T) {
+	tests := []struct {
+		host          string
+		wantName      string
+		wantNamespace string
+		wantService   bool
+		wantErr       bool
+	}{
+		{host: "api.payments.svc.cluster.local", wantName: "api", wantNamespace: "payments", wantService: true},
+		{host: "API.Payments.svc.cluster.local.", wantName: "api", wantNamespace: "payments", wantService: true},
+		{host: "api.payments.svc", wantName: "api", wantNamespace: "payments", wantService: true},
+		{host: "api.payments", wantName: "api", wantNamespace: "payments"},
+		{host: "api", wantName: "api"},
+		{host: "api.svc", wantErr: true},
+		{host: "api.payments.example.com", wantErr: true},
+		{host: "api.", wantName: "api"},
+		{host: "", wantErr: true},
+		{host: ".payments", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			name, namespace, service, err := ParseClusterHost(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClusterHost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || namespace != tt.wantNamespace || service != tt.wantService {
+				t.Errorf("ParseClusterHost() = %q, %q, %v, want %q, %q, %v", name, namespace, service, tt.wantName, tt.wantNamespace, tt.wantService)
+			}
+		})
+	}
+}
+
+func TestParsePodHost(t *testing.T) {
+	tests := []struct {
+		host          string
+		wantName      string
+		wantIP        string
+		wantNamespace string
+		wantOK        bool
+	}{
+		{host: "10-1-2-3.payments.pod.cluster.local", wantIP: "10.1.2.3", wantNamespace: "payments", wantOK: true},
+		{host: "10-1-2-3.Payments.pod.", wantIP: "10.1.2.3", wantNamespace: "payments", wantOK: true},
+		{host: "db-0.db.payments.svc.cluster.local", wantName: "db-0", wantNamespace: "payments", wantOK: true},
+		{host: "db-0.db.payments.svc", wantName: "db-0", wantNamespace: "payments", wantOK: true},
+		{host: "api.payments.pod.cluster.local"},
+		{host: "10-1-2-3.pod.cluster.local"},
+		{host: "api.payments.svc.cluster.local"},
+		{host: "db-0..payments.svc"},
+		{host: "api.payments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			name, ip, namespace, ok := ParsePodHost(tt.host)
+			if name != tt.wantName || ip != tt.wantIP || namespace != tt.wantNamespace || ok != tt.wantOK {
+				t.Errorf("ParsePodHost() = %q, %q, %q, %v, want %q, %q, %q, %v", name, ip, namespace, ok, tt.wantName, tt.wantIP, tt.wantNamespace, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol, see RFC 1928
+const (
+	socksVersion = 0x05
+
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xff
+
+	socksConnect = 0x01
+
+	socksIPv4   = 0x01
+	socksDomain = 0x03
+	socksIPv6   = 0x04
+
+	socksSucceeded           = 0x00
+	socksHostUnreachable     = 0x04
+	socksCommandNotSupported = 0x07
+	socksAddressNotSupported = 0x08
+
+	// socksHandshakeTimeout is the time a client has to send its request
+	socksHandshakeTimeout = 10 * time.Second
+)
+
+// socksError is a failed request, code is replied to the client
+type socksError struct {
+	code byte
+	err  error
+}
+
+func (e socksError) Error() string {
+	return e.err.Error()
+}
+
+// serveSOCKS serves a SOCKS5 proxy on addr in the background. CONNECT requests to names
+// of resources, e.g. api.payments:80, are forwarded to a pod of the resource over a
+// tunnel of the pool.
+func serveSOCKS(addr string, pool *TunnelPool, reportChan chan<- Report) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					reportChan <- NewReport(SeverityError, nil, "server stopped").WithComponent(ComponentProxy).WithErr(err)
+				}
+				return
+			}
+
+			go handleSOCKS(conn, pool, reportChan)
+		}
+	}()
+
+	return l, nil
+}
+
+func handleSOCKS(conn net.Conn, pool *TunnelPool, reportChan chan<- Report) {
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+
+	host, port, err := socksHandshake(conn)
+	if err != nil {
+		var socksErr socksError
+		if errors.As(err, &socksErr) {
+			_ = socksReply(conn, socksErr.code)
+		}
+		reportChan <- NewReport(SeverityWarning, nil, "rejected SOCKS connection from %s", conn.RemoteAddr()).WithComponent(ComponentProxy).WithErr(err)
+		_ = conn.Close()
+		return
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	stream, err := pool.Dial(host, port)
+	if err != nil {
+		_ = socksReply(conn, socksHostUnreachable)
+		reportChan <- NewReport(SeverityError, nil, "error connecting %s to %s", conn.RemoteAddr(), target).WithComponent(ComponentProxy).WithErr(err)
+		_ = conn.Close()
+		return
+	}
+
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		_ = stream.Close()
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	reportChan <- NewReport(SeverityDebug, nil, "connected %s to %s (%s)", conn.RemoteAddr(), target, stream.Target).WithComponent(ComponentProxy)
+	proxyConnection(conn, stream, target, reportChan)
+}
+
+// socksHandshake negotiates the authentication method and reads the request, only
+// CONNECT requests to domain names are supported.
+func socksHandshake(conn net.Conn) (host string, port uint16, err error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", 0, err
+	}
+
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", 0, err
+	}
+	if method == socksNoAcceptable {
+		return "", 0, errors.New("client doesn't support authentication method none")
+	}
+
+	// version, command, reserved, address type
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", 0, err
+	}
+	if request[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+
+	switch request[3] {
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	case socksIPv4, socksIPv6:
+		// pods are addressed by the names of their resources
+		return "", 0, socksError{code: socksAddressNotSupported, err: errors.New("only domain names are supported as address")}
+	default:
+		return "", 0, socksError{code: socksAddressNotSupported, err: fmt.Errorf("unknown address type %d", request[3])}
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return "", 0, err
+	}
+	port = binary.BigEndian.Uint16(portBytes)
+
+	if request[1] != socksConnect {
+		return "", 0, socksError{code: socksCommandNotSupported, err: fmt.Errorf("unsupported command %d", request[1])}
+	}
+
+	return host, port, nil
+}
+
+// socksReply replies to a request, the bound address isn't meaningful for tunnels and left empty
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
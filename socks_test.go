@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestSOCKS(t *testing.T) {
+	pool := NewTunnelPool(newFakeAPIServer(t), "ns")
+	pool.idleTimeout = 100 * time.Millisecond
+	defer pool.Close()
+
+	l, err := serveSOCKS("127.0.0.1:0", pool, drainReports(t))
+	if err != nil {
+		t.Fatalf("serveSOCKS() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	dialer, err := proxy.SOCKS5("tcp", l.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both connections share a tunnel
+	first, err := dialer.Dial("tcp", "foo.ns:80")
+	if err != nil {
+		t.Fatalf("error connecting to foo.ns: %v", err)
+	}
+	second, err := dialer.Dial("tcp", "foo:80")
+	if err != nil {
+		t.Fatalf("error connecting to foo: %v", err)
+	}
+	echo(t, first, "hello")
+	echo(t, second, "world")
+
+	if got := pool.Tunnels(); got != 1 {
+		t.Errorf("expected 1 tunnel, got %d", got)
+	}
+
+	_ = first.Close()
+	_ = second.Close()
+
+	// the tunnel is closed once it's idle
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Tunnels() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := pool.Tunnels(); got != 0 {
+		t.Errorf("expected the idle tunnel to be closed, got %d tunnels", got)
+	}
+
+	for _, addr := range []string{"bar.ns:80", "foo.ns.svc.cluster.local:80", "127.0.0.1:80"} {
+		if conn, err := dialer.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			t.Errorf("expected an error connecting to %s", addr)
+		}
+	}
+}
+
+func TestSOCKSTargetPort(t *testing.T) {
+	pool := NewTunnelPool(newFakeServiceServer(t), "ns")
+	defer pool.Close()
+
+	l, err := serveSOCKS("127.0.0.1:0", pool, drainReports(t))
+	if err != nil {
+		t.Fatalf("serveSOCKS() returned an error: %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	dialer, err := proxy.SOCKS5("tcp", l.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fake pod answers with the port the connection was forwarded to
+	for addr, want := range map[string]string{"web.ns:80": "8080\n", "web:81": "9090\n"} {
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("error connecting to %s: %v", addr, err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		_ = conn.Close()
+		if err != nil || line != want {
+			t.Errorf("got %q (%v) connecting to %s, want %q", line, err, addr, want)
+		}
+	}
+
+	if conn, err := dialer.Dial("tcp", "web.ns:82"); err == nil {
+		_ = conn.Close()
+		t.Errorf("expected an error connecting to a port the service doesn't have")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// ForwardState is the state of a single forward
+type ForwardState int
+
+const (
+	// StateResolving means the pod to forward to is being determined
+	StateResolving ForwardState = iota
+	// StateConnecting means the port forwarding connection to the pod is being established
+	StateConnecting
+	// StateReady means the forward accepts local connections
+	StateReady
+	// StateBackoff means the forward failed and waits to be restarted
+	StateBackoff
+	// StateFailed means the forward failed and won't be restarted
+	StateFailed
+	// StateStopped means the forward was stopped
+	StateStopped
+)
+
+func (s ForwardState) String() string {
+	switch s {
+	case StateResolving:
+		return "Resolving"
+	case StateConnecting:
+		return "Connecting"
+	case StateReady:
+		return "Ready"
+	case StateBackoff:
+		return "Backoff"
+	case StateFailed:
+		return "Failed"
+	case StateStopped:
+		return "Stopped"
+	default:
+		return "Unknown"
+	}
+}
+
+// ForwardStateFromString parses the name of a state as returned by String
+func ForwardStateFromString(s string) (ForwardState, error) {
+	for state := StateResolving; state <= StateStopped; state++ {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+
+	return StateResolving, fmt.Errorf("unknown state: %s", s)
+}
+
+// ForwardedPort is a resolved pair of local and remote port
+type ForwardedPort struct {
+	Local  uint16 `json:"local"`
+	Remote uint16 `json:"remote"`
+}
+
+func (p ForwardedPort) String() string {
+	return fmt.Sprintf("%d:%d", p.Local, p.Remote)
+}
+
+// ForwardStatus is a snapshot of the status of a single forward
+type ForwardStatus struct {
+	ID       string
+	Resource Resource
+	State    ForwardState
+	// Pod is the pod the forward is connected or connecting to
+	Pod string
+	// Ports are the resolved ports, available once the local port is bound
+	Ports []ForwardedPort
+	// LocalAddresses are the addresses local connections are accepted on
+	LocalAddresses []string
+	// Retries is the number of attempts since the forward was ready the last time
+	Retries int
+	// Reconnects is the number of times the forward became ready again
+	Reconnects        int
+	ActiveConnections int
+	// Connections is the number of local connections accepted since the forward was added
+	Connections uint64
+	// BytesIn is the number of bytes received from the pod, BytesOut sent to the pod
+	BytesIn   uint64
+	BytesOut  uint64
+	LastError error
+	// CreatedAt is the time the forward was added
+	CreatedAt time.Time
+	// Since is the time the forward entered its current state
+	Since time.Time
+	// ReadyAt is the last time the forward became ready
+	ReadyAt time.Time
+}
+
+// forwardStatusJSON is the JSON representation of a ForwardStatus
+type forwardStatusJSON struct {
+	ID                string          `json:"id"`
+	Resource          string          `json:"resource"`
+	Namespace         string          `json:"namespace"`
+	Spec              string          `json:"spec"`
+	State             string          `json:"state"`
+	Pod               string          `json:"pod,omitempty"`
+	Ports             []ForwardedPort `json:"ports"`
+	LocalAddresses    []string        `json:"local_addresses"`
+	Retries           int             `json:"retries"`
+	Reconnects        int             `json:"reconnects"`
+	ActiveConnections int             `json:"active_connections"`
+	Connections       uint64          `json:"connections"`
+	BytesIn           uint64          `json:"bytes_in"`
+	BytesOut          uint64          `json:"bytes_out"`
+	LastError         string          `json:"last_error,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Since             time.Time       `json:"since"`
+	ReadyAt           *time.Time      `json:"ready_at,omitempty"`
+}
+
+func (s ForwardStatus) MarshalJSON() ([]byte, error) {
+	doc := forwardStatusJSON{
+		ID:                s.ID,
+		Resource:          s.Resource.Key(),
+		Namespace:         s.Resource.Namespace,
+		Spec:              s.Resource.String(),
+		State:             s.State.String(),
+		Pod:               s.Pod,
+		Ports:             s.Ports,
+		LocalAddresses:    s.LocalAddresses,
+		Retries:           s.Retries,
+		Reconnects:        s.Reconnects,
+		ActiveConnections: s.ActiveConnections,
+		Connections:       s.Connections,
+		BytesIn:           s.BytesIn,
+		BytesOut:          s.BytesOut,
+		CreatedAt:         s.CreatedAt,
+		Since:             s.Since,
+	}
+	if doc.Ports == nil {
+		doc.Ports = []ForwardedPort{}
+	}
+	if doc.LocalAddresses == nil {
+		doc.LocalAddresses = []string{}
+	}
+	if s.LastError != nil {
+		doc.LastError = s.LastError.Error()
+	}
+	if !s.ReadyAt.IsZero() {
+		doc.ReadyAt = &s.ReadyAt
+	}
+
+	return json.Marshal(doc)
+}
+
+func (s *ForwardStatus) UnmarshalJSON(data []byte) error {
+	var doc forwardStatusJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	resource, err := ParseResource(doc.Spec)
+	if err != nil {
+		return err
+	}
+
+	state, err := ForwardStateFromString(doc.State)
+	if err != nil {
+		return err
+	}
+
+	*s = ForwardStatus{
+		ID:                doc.ID,
+		Resource:          resource,
+		State:             state,
+		Pod:               doc.Pod,
+		Ports:             doc.Ports,
+		LocalAddresses:    doc.LocalAddresses,
+		Retries:           doc.Retries,
+		Reconnects:        doc.Reconnects,
+		ActiveConnections: doc.ActiveConnections,
+		Connections:       doc.Connections,
+		BytesIn:           doc.BytesIn,
+		BytesOut:          doc.BytesOut,
+		CreatedAt:         doc.CreatedAt,
+		Since:             doc.Since,
+	}
+	if doc.LastError != "" {
+		s.LastError = errors.New(doc.LastError)
+	}
+	if doc.ReadyAt != nil {
+		s.ReadyAt = *doc.ReadyAt
+	}
+
+	return nil
+}
+
+// permanentError is an error after which a forward isn't restarted
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func isPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
+
+// WriteStatus renders the given statuses as a table
+func WriteStatus(w io.Writer, statuses []ForwardStatus, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "RESOURCE\tPORTS\tPOD\tSTATE\tSINCE\tRETRIES\tLAST ERROR")
+	for _, s := range statuses {
+		ports := s.Resource.Ports
+		if len(s.Ports) > 0 {
+			ports = s.Ports[0].String()
+		}
+
+		pod, lastErr := s.Pod, ""
+		if pod == "" {
+			pod = "-"
+		}
+		if s.LastError != nil {
+			lastErr = s.LastError.Error()
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			s.Resource.Key(), ports, pod, s.State, now.Sub(s.Since).Round(time.Second), s.Retries, lastErr)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []ForwardStatus{
+		{
+			ID:       "ns/service/foo:8080:80",
+			Resource: Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+			State:    StateReady,
+			Pod:      "foo-abc",
+			Ports:    []ForwardedPort{{Local: 8080, Remote: 80}},
+			Since:    now.Add(-time.Minute),
+		},
+		{
+			ID:        "ns/pod/bar:9090:9090",
+			Resource:  Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"},
+			State:     StateBackoff,
+			Retries:   3,
+			LastError: errors.New("pod not found"),
+			Since:     now.Add(-2 * time.Second),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf, statuses, now); err != nil {
+		t.Fatalf("WriteStatus() returned an error: %v", err)
+	}
+
+	want := []string{
+		"RESOURCE        PORTS      POD      STATE    SINCE  RETRIES  LAST ERROR",
+		"ns/service/foo  8080:80    foo-abc  Ready    1m0s   0",
+		"ns/pod/bar      9090:9090  -        Backoff  2s     3        pod not found",
+	}
+	got := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("WriteStatus() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if strings.TrimRight(got[i], " ") != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestForwardStatusMarshalJSON(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	status := ForwardStatus{
+		ID:        "ns/pod/bar:9090:9090",
+		Resource:  Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"},
+		State:     StateBackoff,
+		Retries:   3,
+		LastError: errors.New("pod not found"),
+		CreatedAt: now,
+		Since:     now,
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+
+	want := `{"id":"ns/pod/bar:9090:9090","resource":"ns/pod/bar","namespace":"ns","spec":"ns/pod/bar:9090:9090","state":"Backoff",` +
+		`"ports":[],"local_addresses":[],"retries":3,"reconnects":0,"active_connections":0,"connections":0,"bytes_in":0,"bytes_out":0,` +
+		`"last_error":"pod not found","created_at":"2024-01-01T12:00:00Z","since":"2024-01-01T12:00:00Z"}`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// activationRetryDelay is the time to wait before accepting again after an error
+const activationRetryDelay = 100 * time.Millisecond
+
+// Systemd notifies the service manager about the forwards by the sd_notify protocol:
+// READY=1 once all forwards are ready, STATUS= whenever a forward changed and WATCHDOG=1
+// pings. It's an Observer of the Forwarder.
+type Systemd struct {
+	socket *net.UnixAddr
+	// watchdog is the interval to ping the watchdog at, 0 if it's disabled
+	watchdog   time.Duration
+	reportChan chan<- Report
+	changed    chan struct{}
+}
+
+var _ Observer = &Systemd{}
+
+// NewSystemd returns nil unless run by systemd as service of Type=notify
+func NewSystemd(reportChan chan<- Report) *Systemd {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	return &Systemd{
+		socket:     &net.UnixAddr{Name: socket, Net: "unixgram"},
+		watchdog:   watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID")),
+		reportChan: reportChan,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// watchdogInterval returns half of the watchdog timeout, 0 if the watchdog isn't enabled for this process
+func watchdogInterval(usec, pid string) time.Duration {
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Microsecond / 2
+}
+
+// WatchdogInterval returns the interval Watchdog must be called at, 0 if the watchdog is disabled
+func (s *Systemd) WatchdogInterval() time.Duration {
+	return s.watchdog
+}
+
+// Notify sends the given assignments, e.g. READY=1, to the service manager
+func (s *Systemd) Notify(state ...string) error {
+	conn, err := net.DialUnix("unixgram", nil, s.socket)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	return err
+}
+
+// Watchdog pings the watchdog, unless the forwarder is stuck
+func (s *Systemd) Watchdog(forwarder *Forwarder) error {
+	// blocks if a forward is deadlocked, systemd restarts the service then
+	_ = forwarder.Status()
+
+	return s.Notify("WATCHDOG=1")
+}
+
+func (s *Systemd) StateChanged(ForwardStatus, ForwardState) {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+		// already pending
+	}
+}
+
+func (s *Systemd) PodResolved(ForwardStatus, time.Duration) {}
+
+func (s *Systemd) ConnectionClosed(ForwardStatus, ConnectionStats) {}
+
+// Run sends READY=1 once all forwards are ready and the status whenever a forward changed,
+// until ctx is done.
+func (s *Systemd) Run(ctx context.Context, forwarder *Forwarder) {
+	ready := make(chan error, 1)
+	go func() {
+		ready <- forwarder.WaitReady(ctx)
+	}()
+
+	for {
+		var state []string
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-ready:
+			ready = nil
+			if err != nil {
+				continue
+			}
+			state = []string{"READY=1", "STATUS=" + statusLine(forwarder.Status())}
+		case <-s.changed:
+			state = []string{"STATUS=" + statusLine(forwarder.Status())}
+		}
+
+		if err := s.Notify(state...); err != nil {
+			s.reportChan <- NewReport(SeverityWarning, nil, "error notifying systemd").WithComponent(ComponentSystemd).WithErr(err)
+		}
+	}
+}
+
+// statusLine summarizes the states of the forwards, e.g. "2/3 forwards ready, 1 Backoff"
+func statusLine(statuses []ForwardStatus) string {
+	counts := make(map[ForwardState]int)
+	for _, s := range statuses {
+		counts[s.State]++
+	}
+
+	line := fmt.Sprintf("%d/%d forwards ready", counts[StateReady], len(statuses))
+	for state := StateResolving; state <= StateStopped; state++ {
+		if n := counts[state]; n > 0 && state != StateReady {
+			line += fmt.Sprintf(", %d %s", n, state)
+		}
+	}
+
+	return line
+}
+
+// SocketActivation maps the listeners passed by systemd socket activation to forwards by
+// their names (FileDescriptorName=), which may be the resource key, type/name or the name
+// alone, e.g. ns/service/web, service/web or web. If there are several listeners of a name,
+// the ones bound to the local port of the forward are preferred. Forwards without a listener
+// are bound by the fallback.
+type SocketActivation struct {
+	listeners map[string][]*activatedListener
+	fallback  ListenFunc
+}
+
+// NewSocketActivation takes the listeners passed to this process, if any. The environment
+// variables of socket activation are unset, so they aren't passed to hooks and commands.
+func NewSocketActivation(fallback ListenFunc) (*SocketActivation, error) {
+	defer func() {
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			_ = os.Unsetenv(name)
+		}
+	}()
+
+	listeners, err := listenersFromEnv(os.Getenv, listenFDsStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSocketActivation(listeners, fallback), nil
+}
+
+func newSocketActivation(listeners map[string][]net.Listener, fallback ListenFunc) *SocketActivation {
+	a := &SocketActivation{
+		listeners: make(map[string][]*activatedListener),
+		fallback:  fallback,
+	}
+	for name, ls := range listeners {
+		for _, l := range ls {
+			a.listeners[name] = append(a.listeners[name], &activatedListener{l: l, conns: make(chan net.Conn)})
+		}
+	}
+
+	return a
+}
+
+// listenersFromEnv returns the listeners passed by socket activation by their names, unnamed
+// ones are named "unknown" like by systemd. first is the first passed file descriptor.
+func listenersFromEnv(getenv func(string) string, first int) (map[string][]net.Listener, error) {
+	if pid, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		// not meant for this process
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", getenv("LISTEN_FDS"))
+	}
+
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	listeners := make(map[string][]net.Listener)
+	for i := range n {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(first+i), name)
+		l, err := net.FileListener(f)
+		// the listener uses a duplicate
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error using socket %s: %w", name, err)
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+
+	return listeners, nil
+}
+
+// Names returns the sorted names of all passed listeners
+func (a *SocketActivation) Names() []string {
+	names := make([]string, 0, len(a.listeners))
+	for name := range a.listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Listen is the ListenFunc of the forwarder
+func (a *SocketActivation) Listen(resource Resource, port ForwardedPort) ([]net.Listener, error) {
+	for _, name := range resource.Names() {
+		activated, ok := a.listeners[name]
+		if !ok {
+			continue
+		}
+
+		var matching []*activatedListener
+		for _, l := range activated {
+			if addr, ok := l.l.Addr().(*net.TCPAddr); ok && addr.Port == int(port.Local) {
+				matching = append(matching, l)
+			}
+		}
+		if len(matching) == 0 {
+			matching = activated
+		}
+
+		listeners := make([]net.Listener, 0, len(matching))
+		for _, l := range matching {
+			listeners = append(listeners, l.handle())
+		}
+		return listeners, nil
+	}
+
+	return a.fallback(resource, port)
+}
+
+// activatedListener is a listener passed by systemd, it's kept open for the lifetime of the
+// process, so connections aren't refused while its forward restarts. Forwards accept
+// connections by handles, which can be closed without closing the listener.
+type activatedListener struct {
+	l     net.Listener
+	once  sync.Once
+	conns chan net.Conn
+}
+
+func (a *activatedListener) handle() net.Listener {
+	// connections are accepted once they can be handed over, the others queue up in the backlog
+	a.once.Do(func() {
+		go a.accept()
+	})
+
+	return &activatedHandle{listener: a, closed: make(chan struct{})}
+}
+
+func (a *activatedListener) accept() {
+	for {
+		conn, err := a.l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			time.Sleep(activationRetryDelay)
+			continue
+		}
+
+		a.conns <- conn
+	}
+}
+
+// activatedHandle accepts connections of an activatedListener until it's closed
+type activatedHandle struct {
+	listener *activatedListener
+	once     sync.Once
+	closed   chan struct{}
+}
+
+func (h *activatedHandle) Accept() (net.Conn, error) {
+	select {
+	case <-h.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	select {
+	case <-h.closed:
+		return nil, net.ErrClosed
+	case conn := <-h.listener.conns:
+		return conn, nil
+	}
+}
+
+func (h *activatedHandle) Close() error {
+	h.once.Do(func() {
+		close(h.closed)
+	})
+
+	return nil
+}
+
+func (h *activatedHandle) Addr() net.Addr {
+	return h.listener.l.Addr()
+}
+
+// queueUntilReady keeps connections in the backlog while the forward isn't ready, e.g. after a restart
+func (h *activatedHandle) queueUntilReady() {}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusLine(t *testing.T) {
+	statuses := []ForwardStatus{
+		{State: StateReady},
+		{State: StateReady},
+		{State: StateBackoff},
+		{State: StateFailed},
+	}
+
+	if got, want := statusLine(statuses), "2/4 forwards ready, 1 Backoff, 1 Failed"; got != want {
+		t.Errorf("statusLine() = %q, want %q", got, want)
+	}
+	if got, want := statusLine(nil), "0/0 forwards ready"; got != want {
+		t.Errorf("statusLine() = %q, want %q", got, want)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{usec: "10000000", want: 5 * time.Second},
+		{usec: "10000000", pid: pid, want: 5 * time.Second},
+		{usec: "10000000", pid: "1", want: 0},
+		{usec: "", want: 0},
+		{usec: "invalid", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := watchdogInterval(tt.usec, tt.pid); got != tt.want {
+			t.Errorf("watchdogInterval(%q, %q) = %s, want %s", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+// listenNotifySocket returns a systemd notifying to a socket and a function receiving its messages
+func listenNotifySocket(t *testing.T) (*Systemd, func() string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+
+	// the path of unix sockets is limited in length
+	dir, err := os.MkdirTemp("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	receive := func() string {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("error receiving notification: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	systemd := &Systemd{socket: addr, reportChan: drainReports(t), changed: make(chan struct{}, 1)}
+	return systemd, receive
+}
+
+func TestSystemdNotify(t *testing.T) {
+	systemd, receive := listenNotifySocket(t)
+
+	if err := systemd.Notify("READY=1", "STATUS=fine"); err != nil {
+		t.Fatalf("Notify() returned an error: %v", err)
+	}
+	if got, want := receive(), "READY=1\nSTATUS=fine"; got != want {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
+
+func TestSystemdRun(t *testing.T) {
+	systemd, receive := listenNotifySocket(t)
+
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t), systemd)
+	defer forwarder.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go systemd.Run(ctx, forwarder)
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if msg := receive(); strings.Contains(msg, "READY=1") {
+			if !strings.Contains(msg, "STATUS=1/1 forwards ready") {
+				t.Errorf("expected status with READY=1, got %q", msg)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("READY=1 wasn't sent")
+		}
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listeners can't be passed by file on windows")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	defer func() {
+		_ = f.Close()
+	}()
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "pod/foo",
+	}
+	listeners, err := listenersFromEnv(func(name string) string { return env[name] }, int(f.Fd()))
+	if err != nil {
+		t.Fatalf("listenersFromEnv() returned an error: %v", err)
+	}
+	if len(listeners["pod/foo"]) != 1 {
+		t.Fatalf("expected a listener named pod/foo, got %v", listeners)
+	}
+	port := listeners["pod/foo"][0].Addr().(*net.TCPAddr).Port
+
+	fallback := 0
+	activation := newSocketActivation(listeners, func(r Resource, p ForwardedPort) ([]net.Listener, error) {
+		fallback++
+		return ListenLoopback(r, p)
+	})
+
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+	forwarder.SetListenFunc(activation.Listen)
+
+	resource := Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}
+	if err := forwarder.Add(resource); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+	if status.Ports[0].Local != uint16(port) {
+		t.Errorf("expected local port %d of the activated listener, got %d", port, status.Ports[0].Local)
+	}
+	if fallback != 0 {
+		t.Errorf("expected the activated listener to be used instead of the fallback")
+	}
+
+	// the listener survives the forward, connections made meanwhile are accepted afterward
+	if err := forwarder.Remove(resource.String()); err != nil {
+		t.Fatalf("Remove() returned an error: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("connection to removed forward was refused: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := forwarder.Add(resource); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	waitForState(t, forwarder, StateReady)
+	echo(t, conn, "hello")
+}
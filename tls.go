@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	// caValidity is the lifetime of the CA, it has to be trusted again afterward
+	caValidity = 10 * 365 * 24 * time.Hour
+	// leafValidity is the lifetime of certificates issued for forwards, within the limit of browsers
+	leafValidity = 397 * 24 * time.Hour
+
+	// tlsRecordHandshake is the first byte of a TLS connection
+	tlsRecordHandshake = 0x16
+	// tlsSniffTimeout is the time a connection is waited for to start with a TLS handshake,
+	// it's forwarded as it is afterward, e.g. for protocols the server speaks first
+	tlsSniffTimeout = 300 * time.Millisecond
+)
+
+// configDir returns the directory the CA is kept in, it's created if it doesn't exist.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "kubectl-multiforward")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// LocalCA issues certificates for the names of forwards. It's created once and kept,
+// so it has to be trusted only once, e.g. by the system's trust store.
+type LocalCA struct {
+	// CertPath is the path of the CA certificate which is to be trusted
+	CertPath string
+	cert     *x509.Certificate
+	key      crypto.Signer
+
+	mu sync.Mutex
+	// issued are the certificates by their names
+	issued map[string]*tls.Certificate
+}
+
+// LoadOrCreateCA loads the CA from dir, a new one is created if there is none.
+func LoadOrCreateCA(dir string) (ca *LocalCA, created bool, err error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := createCA(certPath, keyPath); err != nil {
+			return nil, false, fmt.Errorf("error creating CA: %w", err)
+		}
+		created = true
+		certPEM, err = os.ReadFile(certPath)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid CA in %s: %w", dir, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid CA certificate %s: %w", certPath, err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, false, fmt.Errorf("invalid CA in %s", dir)
+	}
+
+	return &LocalCA{
+		CertPath: certPath,
+		cert:     cert,
+		key:      key,
+		issued:   make(map[string]*tls.Certificate),
+	}, created, nil
+}
+
+func createCA(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	name := "kubectl-multiforward"
+	if hostname, err := os.Hostname(); err == nil {
+		name += " " + hostname
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: name + " CA", Organization: []string{"kubectl-multiforward local CA"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// the key is written first, a CA certificate without key isn't loaded
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+
+	return serial
+}
+
+// Issue returns a certificate for the names and addresses, certificates are issued once per process
+func (ca *LocalCA) Issue(names []string, ips []net.IP) (*tls.Certificate, error) {
+	var key strings.Builder
+	key.WriteString(strings.Join(names, ","))
+	for _, ip := range ips {
+		key.WriteString("," + ip.String())
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.issued[key.String()]; ok {
+		return cert, nil
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: names[0], Organization: []string{"kubectl-multiforward"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  leafKey,
+	}
+	ca.issued[key.String()] = cert
+
+	return cert, nil
+}
+
+// tlsNames returns the names a certificate of the resource is issued for, the names it's
+// resolved by in the cluster and below localhost, e.g. api.payments.localhost as routed by the gateway.
+func tlsNames(r Resource) []string {
+	names := hostNames(r)
+	if r.Type == Service {
+		names = append(names, r.Name+"."+r.Namespace+".svc")
+	}
+
+	return append(names, r.Name+"."+r.Namespace+"."+gatewayDomain, r.Name+"."+gatewayDomain, "localhost")
+}
+
+// TLSTerminator serves the local ports of selected forwards as HTTPS with a certificate of
+// the CA, the pod is forwarded plaintext. Forwards are selected by name on the command line,
+// e.g. payments/service/api, service/api or api, or by their ID in the config file.
+//
+// Connections which don't start with a TLS handshake are forwarded as they are, e.g. from the gateway.
+type TLSTerminator struct {
+	// dir is the directory of the CA, the user config dir if empty
+	dir        string
+	names      map[string]bool
+	fallback   ListenFunc
+	reportChan chan<- Report
+
+	mu sync.Mutex
+	// ca is loaded once the first forward is served as HTTPS
+	ca *LocalCA
+	// forwards are the IDs of the forwards selected by the config file
+	forwards map[string]bool
+}
+
+func NewTLSTerminator(dir string, names []string, fallback ListenFunc, reportChan chan<- Report) *TLSTerminator {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	return &TLSTerminator{
+		dir:        dir,
+		names:      selected,
+		fallback:   fallback,
+		reportChan: reportChan,
+		forwards:   make(map[string]bool),
+	}
+}
+
+// loadCA returns the CA, it's loaded or created on first use
+func (t *TLSTerminator) loadCA() (ca *LocalCA, created bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ca != nil {
+		return t.ca, false, nil
+	}
+
+	dir := t.dir
+	if dir == "" {
+		if dir, err = configDir(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	t.ca, created, err = LoadOrCreateCA(dir)
+	return t.ca, created, err
+}
+
+// SetForwards selects the forwards by their IDs in addition to the ones selected by name,
+// forwards which are running already keep their listeners until they are restarted.
+func (t *TLSTerminator) SetForwards(ids map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.forwards = ids
+}
+
+// Enabled returns true if the local ports of the forward are served as HTTPS
+func (t *TLSTerminator) Enabled(resource Resource) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.forwards[resource.String()] {
+		return true
+	}
+
+	return slices.ContainsFunc(resource.Names(), func(name string) bool {
+		return t.names[name]
+	})
+}
+
+// Listen is the ListenFunc of the forwarder, listeners of selected forwards are wrapped to terminate TLS.
+func (t *TLSTerminator) Listen(resource Resource, port ForwardedPort) ([]net.Listener, error) {
+	listeners, err := t.fallback(resource, port)
+	if err != nil || !t.Enabled(resource) {
+		return listeners, err
+	}
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	for _, l := range listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() && !slices.ContainsFunc(ips, addr.IP.Equal) {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		return nil, err
+	}
+
+	ca, created, err := t.loadCA()
+	if err != nil {
+		return fail(fmt.Errorf("error loading CA: %w", err))
+	}
+	if created {
+		t.reportChan <- NewReport(SeverityWarning, nil, "created a local CA, trust %s to accept the certificates of forwards served as HTTPS", ca.CertPath).WithComponent(ComponentTLS)
+	}
+
+	cert, err := ca.Issue(tlsNames(resource), ips)
+	if err != nil {
+		return fail(fmt.Errorf("error issuing certificate: %w", err))
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12}
+
+	wrapped := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		if _, ok := l.(queueingListener); ok {
+			wrapped = append(wrapped, queueingTLSListener{tlsListener{Listener: l, config: config}})
+		} else {
+			wrapped = append(wrapped, tlsListener{Listener: l, config: config})
+		}
+	}
+
+	return wrapped, nil
+}
+
+// tlsListener terminates TLS of the connections it accepts
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (l tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsSniffConn{Conn: conn, config: l.config}, nil
+}
+
+// queueingTLSListener terminates TLS of the connections of a queueingListener
+type queueingTLSListener struct {
+	tlsListener
+}
+
+func (queueingTLSListener) queueUntilReady() {}
+
+// tlsSniffConn is a connection which is served as TLS if it starts with a TLS handshake. The
+// first byte is waited for by the first Read or Write for up to tlsSniffTimeout, connections
+// the client doesn't speak first on are served plain.
+type tlsSniffConn struct {
+	net.Conn
+	config *tls.Config
+
+	once sync.Once
+	conn net.Conn
+}
+
+func (c *tlsSniffConn) sniff() net.Conn {
+	c.once.Do(func() {
+		buffered := &bufferedConn{Conn: c.Conn, reader: bufio.NewReader(c.Conn)}
+		c.conn = buffered
+
+		// the server may speak first, writes must not wait for the client forever
+		if err := c.Conn.SetReadDeadline(time.Now().Add(tlsSniffTimeout)); err != nil {
+			return
+		}
+		first, err := buffered.reader.Peek(1)
+		if err := c.Conn.SetReadDeadline(time.Time{}); err != nil {
+			return
+		}
+
+		// errors but the timeout are returned by the next read as well
+		if err == nil && first[0] == tlsRecordHandshake {
+			c.conn = tls.Server(buffered, c.config)
+		}
+	})
+
+	return c.conn
+}
+
+func (c *tlsSniffConn) Read(p []byte) (int, error) {
+	return c.sniff().Read(p)
+}
+
+func (c *tlsSniffConn) Write(p []byte) (int, error) {
+	return c.sniff().Write(p)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, created, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA() returned an error: %v", err)
+	}
+	if !created {
+		t.Errorf("expected the CA to be created")
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, caKeyFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("key has mode %o, want 600", perm)
+		}
+	}
+
+	loaded, created, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA() returned an error: %v", err)
+	}
+	if created || !loaded.cert.Equal(ca.cert) {
+		t.Errorf("expected the CA to be loaded")
+	}
+
+	cert, err := ca.Issue([]string{"api.payments", "localhost"}, []net.IP{net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Issue() returned an error: %v", err)
+	}
+	if again, _ := ca.Issue([]string{"api.payments", "localhost"}, []net.IP{net.IPv4(127, 0, 0, 1)}); again != cert {
+		t.Errorf("expected the certificate to be issued once")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(loaded.cert)
+	for _, name := range []string{"api.payments", "localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Errorf("certificate isn't valid for %s: %v", name, err)
+		}
+	}
+}
+
+func TestTLSTerminatorEnabled(t *testing.T) {
+	terminator := NewTLSTerminator(t.TempDir(), []string{"service/web", "payments/service/api"}, ListenLoopback, drainReports(t))
+	terminator.SetForwards(map[string]bool{"orders/pod/db-0:5432:5432": true})
+
+	tests := []struct {
+		resource Resource
+		want     bool
+	}{
+		{resource: Resource{Type: Service, Namespace: "payments", Name: "web", Ports: "8443:80"}, want: true},
+		{resource: Resource{Type: Deployment, Namespace: "payments", Name: "web", Ports: "8443:80"}, want: false},
+		{resource: Resource{Type: Service, Namespace: "payments", Name: "api", Ports: "8080:80"}, want: true},
+		{resource: Resource{Type: Service, Namespace: "orders", Name: "api", Ports: "8080:80"}, want: false},
+		{resource: Resource{Type: Pod, Namespace: "orders", Name: "db-0", Ports: "5432:5432"}, want: true},
+		{resource: Resource{Type: Pod, Namespace: "orders", Name: "db-0", Ports: "5433:5432"}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := terminator.Enabled(tt.resource); got != tt.want {
+			t.Errorf("Enabled(%s) = %v, want %v", tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestTLSTerminator(t *testing.T) {
+	dir := t.TempDir()
+	terminator := NewTLSTerminator(dir, []string{"foo"}, ListenLoopback, drainReports(t))
+
+	forwarder := NewForwarder(newFakeAPIServer(t), drainReports(t))
+	defer forwarder.Stop()
+	forwarder.SetListenFunc(terminator.Listen)
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:80"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+
+	ca, _, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	for _, name := range []string{"foo.ns", "foo.ns.localhost", "localhost"} {
+		t.Run(name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", status.LocalAddresses[0], &tls.Config{ServerName: name, RootCAs: roots})
+			if err != nil {
+				t.Fatalf("error connecting to forward: %v", err)
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			echo(t, conn, "hello")
+		})
+	}
+
+	// connections without TLS handshake are forwarded as they are
+	conn, err := net.Dial("tcp", status.LocalAddresses[0])
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	echo(t, conn, "hello")
+}
+
+func TestTLSTerminatorServerSpeaksFirst(t *testing.T) {
+	terminator := NewTLSTerminator(t.TempDir(), []string{"foo"}, ListenLoopback, drainReports(t))
+
+	forwarder := NewForwarder(newFakePodServer(t, func(stream io.ReadWriter) {
+		_, _ = fmt.Fprintln(stream, "220 ready")
+		_, _ = io.Copy(stream, stream)
+	}), drainReports(t))
+	defer forwarder.Stop()
+	forwarder.SetListenFunc(terminator.Listen)
+
+	if err := forwarder.Add(Resource{Type: Pod, Namespace: "ns", Name: "foo", Ports: "0:25"}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	status := waitForState(t, forwarder, StateReady)
+
+	conn, err := net.Dial("tcp", status.LocalAddresses[0])
+	if err != nil {
+		t.Fatalf("error connecting to forward: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// the greeting is passed through without the client sending anything
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	if line, err := reader.ReadString('\n'); err != nil || line != "220 ready\n" {
+		t.Fatalf("got %q (%v), want the greeting", line, err)
+	}
+
+	_, _ = fmt.Fprintln(conn, "hello")
+	if line, err := reader.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("got %q (%v), want the message echoed", line, err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// podTunnel is a port forwarding connection to a single pod,
+// streams to any port of the pod can be opened over it.
+type podTunnel struct {
+	pod       string
+	conn      httpstream.Connection
+	requestID atomic.Int64
+}
+
+// dialPod establishes a port forwarding connection to the given pod.
+func dialPod(config *rest.Config, namespace, pod string) (*podTunnel, error) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building round tripper: %w", err)
+	}
+
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", namespace, pod)
+
+	serverURL, err := url.Parse(config.Host + path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing k8s server URL '%s'  -> %s", config.Host, err)
+	}
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, serverURL)
+
+	conn, protocol, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("error upgrading connection: %w", err)
+	}
+
+	if protocol != portforward.PortForwardProtocolV1Name {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unable to negotiate protocol: client supports %q, server returned %q", portforward.PortForwardProtocolV1Name, protocol)
+	}
+
+	return &podTunnel{pod: pod, conn: conn}, nil
+}
+
+// Done returns a channel which is closed when the tunnel is closed, either locally or by the server.
+func (t *podTunnel) Done() <-chan bool {
+	return t.conn.CloseChan()
+}
+
+// Close closes the tunnel and resets all open streams.
+func (t *podTunnel) Close() error {
+	return t.conn.Close()
+}
+
+// Dial opens a stream to the given port of the pod.
+func (t *podTunnel) Dial(port uint16) (*podStream, error) {
+	requestID := t.requestID.Add(1)
+
+	headers := http.Header{}
+	headers.Set(v1.StreamType, v1.StreamTypeError)
+	headers.Set(v1.PortHeader, strconv.Itoa(int(port)))
+	headers.Set(v1.PortForwardRequestIDHeader, strconv.FormatInt(requestID, 10))
+
+	errorStream, err := t.conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("error creating error stream for port %d: %w", port, err)
+	}
+	// we're not writing to this stream
+	_ = errorStream.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errChan <- fmt.Errorf("error reading from error stream for port %d: %w", port, err)
+		case len(message) > 0:
+			errChan <- fmt.Errorf("an error occurred forwarding port %d: %s", port, message)
+		}
+		close(errChan)
+	}()
+
+	headers.Set(v1.StreamType, v1.StreamTypeData)
+	dataStream, err := t.conn.CreateStream(headers)
+	if err != nil {
+		t.conn.RemoveStreams(errorStream)
+		return nil, fmt.Errorf("error creating forwarding stream for port %d: %w", port, err)
+	}
+
+	return &podStream{
+		conn:        t.conn,
+		dataStream:  dataStream,
+		errorStream: errorStream,
+		errChan:     errChan,
+	}, nil
+}
+
+// podStream is a single connection to a port of the pod
+type podStream struct {
+	conn        httpstream.Connection
+	dataStream  httpstream.Stream
+	errorStream httpstream.Stream
+	errChan     <-chan error
+}
+
+func (s *podStream) Read(p []byte) (int, error) {
+	return s.dataStream.Read(p)
+}
+
+func (s *podStream) Write(p []byte) (int, error) {
+	return s.dataStream.Write(p)
+}
+
+// CloseWrite informs the server that no more data will be sent.
+func (s *podStream) CloseWrite() error {
+	return s.dataStream.Close()
+}
+
+// Close discards all unsent data and returns the error reported by the server, if any.
+func (s *podStream) Close() error {
+	// the data stream must be reset before waiting for the error stream,
+	// otherwise blocked data prevents the error stream from being closed
+	_ = s.dataStream.Reset()
+	defer s.conn.RemoveStreams(s.dataStream, s.errorStream)
+
+	return <-s.errChan
+}
+
+// tapReader passes all data read from r to tap, before it's written anywhere else,
+// so taps of both directions see the data in the order it was exchanged
+type tapReader struct {
+	r   io.Reader
+	tap func([]byte)
+}
+
+func (t tapReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.tap(p[:n])
+	}
+
+	return n, err
+}
+
+// tap wraps r, so that all data read from it is passed to fn, nil fn means no tap
+func tap(r io.Reader, fn func([]byte)) io.Reader {
+	if fn == nil {
+		return r
+	}
+
+	return tapReader{r: r, tap: fn}
+}
+
+// splice copies data between the local connection and the pod stream until the
+// pod side is finished or copying from the local side fails. The data read
+// in each direction is passed to fromPod and toPod, both may be nil.
+// localFirst reports whether the local side finished before the pod side.
+func splice(local net.Conn, remote *podStream, fromPod, toPod func([]byte)) (localFirst bool, err error) {
+	remoteDone := make(chan error, 1)
+	localDone := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(local, tap(remote, fromPod))
+		remoteDone <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(remote, tap(local, toPod))
+		// inform server we're not sending any more data
+		_ = remote.CloseWrite()
+		localDone <- err
+	}()
+
+	select {
+	case err := <-remoteDone:
+		return false, ignoreClosed(err)
+	case err := <-localDone:
+		if err = ignoreClosed(err); err != nil {
+			return true, fmt.Errorf("error copying from local connection to pod: %w", err)
+		}
+		return true, ignoreClosed(<-remoteDone)
+	}
+}
+
+func ignoreClosed(err error) error {
+	if err == nil || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// uiRefreshInterval is the interval the dashboard is redrawn
+const uiRefreshInterval = time.Second
+
+// uiLogLines is the number of log lines kept for the log pane
+const uiLogLines = 500
+
+// logBuffer keeps the last lines written to it
+type logBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	size    int
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{size: size}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines = append(b.lines, string(data[:i]))
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+
+	if len(b.lines) > b.size {
+		b.lines = append([]string(nil), b.lines[len(b.lines)-b.size:]...)
+	}
+
+	return len(p), nil
+}
+
+// Last returns the last n lines
+func (b *logBuffer) Last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > len(b.lines) {
+		n = len(b.lines)
+	}
+
+	return append([]string(nil), b.lines[len(b.lines)-n:]...)
+}
+
+// uiKey is an action triggered by a key press
+type uiKey int
+
+const (
+	keyUp uiKey = iota
+	keyDown
+	keyRestart
+	keyStop
+	keyRepick
+	keyQuit
+)
+
+// parseKeys translates the bytes read from a raw terminal into keys, unknown bytes are ignored
+func parseKeys(p []byte) []uiKey {
+	var keys []uiKey
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case 'k':
+			keys = append(keys, keyUp)
+		case 'j':
+			keys = append(keys, keyDown)
+		case 'r':
+			keys = append(keys, keyRestart)
+		case 's':
+			keys = append(keys, keyStop)
+		case 'p':
+			keys = append(keys, keyRepick)
+		case 'q', 3: // 3 is ctrl+c
+			keys = append(keys, keyQuit)
+		case 0x1b: // escape sequences of arrow keys: ESC [ A and ESC [ B
+			if i+2 < len(p) && p[i+1] == '[' {
+				switch p[i+2] {
+				case 'A':
+					keys = append(keys, keyUp)
+				case 'B':
+					keys = append(keys, keyDown)
+				}
+				i += 2
+			}
+		}
+	}
+
+	return keys
+}
+
+// throughput is the number of bytes per second transferred by a forward
+type throughput struct {
+	in, out float64
+}
+
+// sample is the number of bytes transferred by a forward at a point in time
+type sample struct {
+	in, out uint64
+	at      time.Time
+}
+
+// dashboard is a full-screen terminal UI showing the status of all forwards
+type dashboard struct {
+	forwarder  *Forwarder
+	reportChan chan<- Report
+	logs       *logBuffer
+
+	in        *os.File
+	out       io.Writer
+	oldState  *term.State
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	selected int
+	samples  map[string]sample
+	rates    map[string]throughput
+}
+
+// newDashboard switches the terminal into raw mode and the alternate screen,
+// Close has to be called to restore it.
+func newDashboard(forwarder *Forwarder, reportChan chan<- Report, in *os.File, out io.Writer) (*dashboard, error) {
+	oldState, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("error switching terminal into raw mode: %w", err)
+	}
+
+	// alternate screen and hidden cursor
+	_, _ = fmt.Fprint(out, "\033[?1049h\033[?25l")
+
+	return &dashboard{
+		forwarder:  forwarder,
+		reportChan: reportChan,
+		logs:       newLogBuffer(uiLogLines),
+		in:         in,
+		out:        out,
+		oldState:   oldState,
+		samples:    make(map[string]sample),
+		rates:      make(map[string]throughput),
+	}, nil
+}
+
+// Close restores the terminal, it may be called more than once
+func (d *dashboard) Close() {
+	d.closeOnce.Do(func() {
+		_, _ = fmt.Fprint(d.out, "\033[?25h\033[?1049l")
+		_ = term.Restore(int(d.in.Fd()), d.oldState)
+	})
+}
+
+// Keys reads key presses until the input is closed
+func (d *dashboard) Keys() <-chan uiKey {
+	keys := make(chan uiKey)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := d.in.Read(buf)
+			for _, k := range parseKeys(buf[:n]) {
+				keys <- k
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return keys
+}
+
+// HandleKey applies the action of the key, it returns false if the dashboard should quit
+func (d *dashboard) HandleKey(k uiKey) bool {
+	statuses := d.forwarder.Status()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch k {
+	case keyQuit:
+		return false
+	case keyUp:
+		if d.selected > 0 {
+			d.selected--
+		}
+	case keyDown:
+		if d.selected < len(statuses)-1 {
+			d.selected++
+		}
+	case keyRestart, keyStop, keyRepick:
+		if d.selected >= len(statuses) {
+			return true
+		}
+		id := statuses[d.selected].ID
+
+		// forwarder calls block until the forward is stopped
+		go func() {
+			var err error
+			switch k {
+			case keyRestart:
+				err = d.forwarder.Restart(id)
+			case keyStop:
+				err = d.forwarder.Remove(id)
+			case keyRepick:
+				err = d.forwarder.Repick(id)
+			}
+			if err != nil {
+				d.reportChan <- NewReport(SeverityError, nil, "error changing forward %s", id).WithErr(err)
+			}
+		}()
+	}
+
+	return true
+}
+
+// Render draws the dashboard
+func (d *dashboard) Render() {
+	width, height, err := term.GetSize(int(d.in.Fd()))
+	if err != nil {
+		width, height = 120, 40
+	}
+
+	statuses := d.forwarder.Status()
+	now := time.Now()
+
+	d.mu.Lock()
+	d.updateRates(statuses, now)
+	if d.selected >= len(statuses) && len(statuses) > 0 {
+		d.selected = len(statuses) - 1
+	}
+	selected := d.selected
+	rates := make(map[string]throughput, len(d.rates))
+	for id, r := range d.rates {
+		rates[id] = r
+	}
+	d.mu.Unlock()
+
+	var frame bytes.Buffer
+	renderDashboard(&frame, statuses, rates, selected, d.logs.Last(height), width, height, now)
+	_, _ = d.out.Write(frame.Bytes())
+}
+
+// updateRates computes the throughput since the last sample, must be called with d.mu held.
+func (d *dashboard) updateRates(statuses []ForwardStatus, now time.Time) {
+	current := make(map[string]bool, len(statuses))
+	for _, s := range statuses {
+		current[s.ID] = true
+	}
+	for id := range d.samples {
+		if !current[id] {
+			delete(d.samples, id)
+			delete(d.rates, id)
+		}
+	}
+
+	for _, s := range statuses {
+		prev, ok := d.samples[s.ID]
+		if ok && now.Sub(prev.at) < uiRefreshInterval/2 {
+			continue
+		}
+		if ok && (s.BytesIn < prev.in || s.BytesOut < prev.out) {
+			// the counters are reset by a restart, the rate is known by the next sample
+			delete(d.rates, s.ID)
+		} else if ok {
+			elapsed := now.Sub(prev.at).Seconds()
+			d.rates[s.ID] = throughput{
+				in:  float64(s.BytesIn-prev.in) / elapsed,
+				out: float64(s.BytesOut-prev.out) / elapsed,
+			}
+		}
+		d.samples[s.ID] = sample{in: s.BytesIn, out: s.BytesOut, at: now}
+	}
+}
+
+// renderDashboard writes a complete frame of the dashboard to w, lines are cut at width.
+func renderDashboard(w io.Writer, statuses []ForwardStatus, rates map[string]throughput, selected int, logs []string, width, height int, now time.Time) {
+	var table bytes.Buffer
+	tw := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "LOCAL\tRESOURCE\tPOD\tSTATE\tUPTIME\tRECONNECTS\tCONNS\tIN\tOUT\tLAST ERROR")
+	for _, s := range statuses {
+		local, pod, uptime, lastErr := "-", "-", "-", ""
+		if len(s.LocalAddresses) > 0 {
+			local = s.LocalAddresses[0]
+		}
+		if s.Pod != "" {
+			pod = s.Pod
+		}
+		if s.State == StateReady {
+			uptime = now.Sub(s.ReadyAt).Round(time.Second).String()
+		}
+		if s.LastError != nil {
+			lastErr = strings.ReplaceAll(s.LastError.Error(), "\n", " ")
+		}
+		rate := rates[s.ID]
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			local, s.Resource.Key(), pod, s.State, uptime, s.Reconnects, s.ActiveConnections,
+			formatRate(rate.in), formatRate(rate.out), lastErr)
+	}
+	_ = tw.Flush()
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("kubectl-multiforward: %d forwards  [↑/↓] select  [r]estart  [s]top  [p] re-pick pod  [q]uit", len(statuses)))
+	lines = append(lines, "")
+
+	header := len(lines)
+	rows := strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+	lines = append(lines, rows[0])
+
+	// the rows are scrolled to keep the selected one in view, the log separator is kept below
+	rows = rows[1:]
+	first := 0
+	if visible := max(1, height-len(lines)-2); len(rows) > visible {
+		first = min(max(0, selected-visible/2), len(rows)-visible)
+		rows = rows[first : first+visible]
+	}
+	lines = append(lines, rows...)
+	selectedLine := header + 1 + selected - first
+
+	lines = append(lines, "", "── log "+strings.Repeat("─", max(0, width-7)))
+
+	if remaining := height - len(lines); remaining > 0 {
+		if len(logs) > remaining {
+			logs = logs[len(logs)-remaining:]
+		}
+		lines = append(lines, logs...)
+	}
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[H")
+	for i, line := range lines {
+		line = truncate(line, width)
+		switch {
+		case i == header:
+			line = "\033[1m" + line + "\033[0m"
+		case i == selectedLine && len(statuses) > 0:
+			line = "\033[7m" + line + "\033[0m"
+		}
+		sb.WriteString(line + "\033[K")
+		if i < len(lines)-1 {
+			// the terminal is in raw mode, a line feed doesn't return the carriage
+			sb.WriteString("\r\n")
+		}
+	}
+	sb.WriteString("\033[J")
+
+	_, _ = io.WriteString(w, sb.String())
+}
+
+// truncate cuts s after width runes
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if width < 0 || len(runes) <= width {
+		return s
+	}
+
+	return string(runes[:width])
+}
+
+// formatRate formats bytes per second in a human-readable way
+func formatRate(bytesPerSecond float64) string {
+	const unit = 1024
+	if bytesPerSecond < unit {
+		return fmt.Sprintf("%.0fB/s", bytesPerSecond)
+	}
+
+	div, exp := float64(unit), 0
+	for n := bytesPerSecond / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB/s", bytesPerSecond/div, "KMGT"[exp])
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []uiKey
+	}{
+		{in: "jk", want: []uiKey{keyDown, keyUp}},
+		{in: "\033[A\033[B", want: []uiKey{keyUp, keyDown}},
+		{in: "rsp", want: []uiKey{keyRestart, keyStop, keyRepick}},
+		{in: "q", want: []uiKey{keyQuit}},
+		{in: "\x03", want: []uiKey{keyQuit}},
+		{in: "x\033[C", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseKeys([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeys(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := newLogBuffer(2)
+	_, _ = fmt.Fprint(b, "one\ntwo\nthr")
+	_, _ = fmt.Fprint(b, "ee\n")
+
+	if got, want := b.Last(5), []string{"two", "three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Last(5) = %v, want %v", got, want)
+	}
+	if got, want := b.Last(1), []string{"three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Last(1) = %v, want %v", got, want)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := map[float64]string{
+		0:               "0B/s",
+		512:             "512B/s",
+		2048:            "2.0KiB/s",
+		3 * 1024 * 1024: "3.0MiB/s",
+	}
+
+	for in, want := range tests {
+		if got := formatRate(in); got != want {
+			t.Errorf("formatRate(%v) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRenderDashboard(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []ForwardStatus{
+		{
+			ID:                "ns/service/foo:8080:80",
+			Resource:          Resource{Type: Service, Namespace: "ns", Name: "foo", Ports: "8080:80"},
+			State:             StateReady,
+			Pod:               "foo-abc",
+			LocalAddresses:    []string{"127.0.0.1:8080"},
+			Reconnects:        2,
+			ActiveConnections: 1,
+			ReadyAt:           now.Add(-time.Minute),
+		},
+		{
+			ID:        "ns/pod/bar:9090:9090",
+			Resource:  Resource{Type: Pod, Namespace: "ns", Name: "bar", Ports: "9090:9090"},
+			State:     StateBackoff,
+			LastError: errors.New("pod not found"),
+		},
+	}
+	rates := map[string]throughput{"ns/service/foo:8080:80": {in: 2048, out: 100}}
+
+	var buf bytes.Buffer
+	renderDashboard(&buf, statuses, rates, 1, []string{"[INFO] first", "[INFO] second"}, 200, 8, now)
+	lines := strings.Split(buf.String(), "\r\n")
+
+	if len(lines) != 8 {
+		t.Fatalf("got %d lines, want 8:\n%s", len(lines), buf.String())
+	}
+
+	for i, want := range map[int][]string{
+		2: {"LOCAL", "RESOURCE", "LAST ERROR"},
+		3: {"127.0.0.1:8080", "ns/service/foo", "foo-abc", "Ready", "1m0s", "2.0KiB/s", "100B/s"},
+		4: {"\033[7m", "ns/pod/bar", "Backoff", "pod not found"},
+		6: {"── log"},
+		7: {"[INFO] second"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(lines[i], w) {
+				t.Errorf("line %d = %q, want it to contain %q", i, lines[i], w)
+			}
+		}
+	}
+}
+
+func TestRenderDashboardScrolls(t *testing.T) {
+	var statuses []ForwardStatus
+	for i := range 20 {
+		name := fmt.Sprintf("pod-%02d", i)
+		statuses = append(statuses, ForwardStatus{ID: "ns/pod/" + name + ":0:80", Resource: Resource{Type: Pod, Namespace: "ns", Name: name, Ports: "0:80"}})
+	}
+
+	var buf bytes.Buffer
+	renderDashboard(&buf, statuses, nil, 15, nil, 200, 10, time.Now())
+	lines := strings.Split(buf.String(), "\r\n")
+
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[2], "LOCAL") {
+		t.Errorf("line 2 = %q, want the header", lines[2])
+	}
+	if !strings.Contains(lines[9], "── log") {
+		t.Errorf("line 9 = %q, want the log separator", lines[9])
+	}
+
+	selected := -1
+	for i, line := range lines {
+		if strings.Contains(line, "\033[7m") {
+			selected = i
+			if !strings.Contains(line, "pod-15") {
+				t.Errorf("selected line %q, want pod-15", line)
+			}
+		}
+	}
+	if selected < 3 || selected > 7 {
+		t.Errorf("selected row is on line %d, want it within the table", selected)
+	}
+}
+
+func TestDashboardRatesAfterRestart(t *testing.T) {
+	d := &dashboard{samples: make(map[string]sample), rates: make(map[string]throughput)}
+	now := time.Now()
+
+	d.updateRates([]ForwardStatus{{ID: "foo", BytesIn: 1000, BytesOut: 1000}}, now)
+	d.updateRates([]ForwardStatus{{ID: "foo", BytesIn: 3000, BytesOut: 2000}}, now.Add(time.Second))
+	if got := d.rates["foo"]; got.in != 2000 || got.out != 1000 {
+		t.Errorf("got rate %+v, want 2000 in and 1000 out", got)
+	}
+
+	// the counters are reset by a restart
+	d.updateRates([]ForwardStatus{{ID: "foo", BytesIn: 10, BytesOut: 0}}, now.Add(2*time.Second))
+	if got := d.rates["foo"]; got.in != 0 || got.out != 0 {
+		t.Errorf("got rate %+v after a restart, want none", got)
+	}
+	d.updateRates([]ForwardStatus{{ID: "foo", BytesIn: 110, BytesOut: 50}}, now.Add(3*time.Second))
+	if got := d.rates["foo"]; got.in != 100 || got.out != 50 {
+		t.Errorf("got rate %+v, want 100 in and 50 out", got)
+	}
+}